@@ -3,6 +3,8 @@ package client
 import (
 	"bufio"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"log"
@@ -10,11 +12,13 @@ import (
 	"path/filepath"
 	"pc-book/pb"
 	"pc-book/sample"
+	"pc-book/units"
 	"strings"
 	"time"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 )
 
 func TestRateLaptop(client pb.LaptopServiceClient) {
@@ -70,6 +74,12 @@ func RateLaptops(client pb.LaptopServiceClient, laptopIds []string, scores []flo
 				return
 			}
 
+			err = status.ErrorProto(res.GetStatus())
+			if err != nil {
+				log.Printf("cannot rate laptop %s: %v", res.GetLaptopId(), err)
+				continue
+			}
+
 			log.Print("received reaponse: ", res)
 		}
 	}()
@@ -102,6 +112,17 @@ func UploadImage(client pb.LaptopServiceClient, laptopId string, imagePath strin
 	}
 	defer file.Close()
 
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		log.Fatalf("cannot read image file: %v", err)
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		log.Fatalf("cannot rewind image file: %v", err)
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -115,6 +136,7 @@ func UploadImage(client pb.LaptopServiceClient, laptopId string, imagePath strin
 			Info: &pb.ImageInfo{
 				LaptopId:  laptopId,
 				ImageType: filepath.Ext(imagePath),
+				Checksum:  hex.EncodeToString(hash.Sum(nil)),
 			},
 		},
 	})
@@ -153,6 +175,192 @@ func UploadImage(client pb.LaptopServiceClient, laptopId string, imagePath strin
 	log.Printf("image uploaded with id %s, size %d", res.GetId(), res.GetSize())
 }
 
+const (
+	RESUMABLE_CHUNK_SIZE = 64 << 10
+	MAX_UPLOAD_RETRIES   = 3
+)
+
+// ResumableUploadImage uploads an image through an upload session. When a chunk fails,
+// it asks the server how many bytes were committed and resumes from there.
+func ResumableUploadImage(client pb.LaptopServiceClient, laptopId string, imagePath string) (*pb.ImageInfo, error) {
+	data, err := os.ReadFile(imagePath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read image file: %w", err)
+	}
+
+	checksum := sha256.Sum256(data)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	session, err := client.StartUpload(ctx, &pb.StartUploadRequest{
+		Info: &pb.ImageInfo{
+			LaptopId:  laptopId,
+			ImageType: filepath.Ext(imagePath),
+		},
+		Size:     uint64(len(data)),
+		Checksum: hex.EncodeToString(checksum[:]),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot start upload: %w", err)
+	}
+
+	offset, retries := uint64(0), 0
+	for offset < uint64(len(data)) {
+		end := offset + RESUMABLE_CHUNK_SIZE
+		if end > uint64(len(data)) {
+			end = uint64(len(data))
+		}
+
+		res, err := client.UploadChunk(ctx, &pb.UploadChunkRequest{
+			UploadId:  session.GetUploadId(),
+			Offset:    offset,
+			ChunkData: data[offset:end],
+		})
+		if err == nil {
+			offset = res.GetCommittedSize()
+			continue
+		}
+
+		retries++
+		if retries > MAX_UPLOAD_RETRIES {
+			return nil, fmt.Errorf("cannot upload chunk: %w", err)
+		}
+
+		log.Printf("cannot upload chunk at offset %d, resuming: %v", offset, err)
+
+		query, err := client.QueryUpload(ctx, &pb.QueryUploadRequest{UploadId: session.GetUploadId()})
+		if err != nil {
+			return nil, fmt.Errorf("cannot query upload: %w", err)
+		}
+
+		offset = query.GetCommittedSize()
+	}
+
+	res, err := client.FinishUpload(ctx, &pb.FinishUploadRequest{UploadId: session.GetUploadId()})
+	if err != nil {
+		return nil, fmt.Errorf("cannot finish upload: %w", err)
+	}
+
+	log.Printf("image uploaded with id %s, size %d", res.GetImage().GetImageId(), res.GetImage().GetSize())
+
+	return res.GetImage(), nil
+}
+
+// DownloadImage writes an image to imagePath. An empty variant downloads the original image,
+// otherwise the named thumbnail variant.
+func DownloadImage(client pb.LaptopServiceClient, imageId, variant string, imagePath string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.DownloadImage(ctx, &pb.DownloadImageRequest{ImageId: imageId, Variant: variant})
+	if err != nil {
+		return fmt.Errorf("cannot download image: %w", err)
+	}
+
+	res, err := stream.Recv()
+	if err != nil {
+		return fmt.Errorf("cannot receive image info: %w", err)
+	}
+
+	info := res.GetInfo()
+	if info == nil {
+		return fmt.Errorf("image info is not received")
+	}
+
+	file, err := os.Create(imagePath)
+	if err != nil {
+		return fmt.Errorf("cannot create image file: %w", err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	writer := io.MultiWriter(file, hash)
+
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("cannot receive chunk data: %w", err)
+		}
+
+		_, err = writer.Write(res.GetChunkData())
+		if err != nil {
+			return fmt.Errorf("cannot write chunk data: %w", err)
+		}
+	}
+
+	expected, size := info.GetChecksum(), info.GetSize()
+	for _, other := range info.GetVariants() {
+		if variant != "" && other.GetName() == variant {
+			expected, size = other.GetChecksum(), other.GetSize()
+		}
+	}
+
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	if checksum != expected {
+		return fmt.Errorf("image checksum mismatch: got %s, expected %s", checksum, expected)
+	}
+
+	log.Printf("image %s downloaded to %s, size %d", imageId, imagePath, size)
+
+	return nil
+}
+
+func ListLaptopImages(client pb.LaptopServiceClient, laptopId string) ([]*pb.ImageInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := client.ListLaptopImages(ctx, &pb.ListLaptopImagesRequest{LaptopId: laptopId})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list laptop images: %w", err)
+	}
+
+	for _, image := range res.GetImages() {
+		log.Printf("-- image %s: position %d, primary %v, size %d", image.GetImageId(), image.GetPosition(), image.GetPrimary(), image.GetSize())
+	}
+
+	return res.GetImages(), nil
+}
+
+func DeleteImage(client pb.LaptopServiceClient, imageId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := client.DeleteImage(ctx, &pb.DeleteImageRequest{ImageId: imageId})
+	if err != nil {
+		return fmt.Errorf("cannot delete image: %w", err)
+	}
+
+	return nil
+}
+
+func SetPrimaryImage(client pb.LaptopServiceClient, laptopId, imageId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := client.SetPrimaryImage(ctx, &pb.SetPrimaryImageRequest{LaptopId: laptopId, ImageId: imageId})
+	if err != nil {
+		return fmt.Errorf("cannot set primary image: %w", err)
+	}
+
+	return nil
+}
+
+func ReorderImages(client pb.LaptopServiceClient, laptopId string, imageIds []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := client.ReorderImages(ctx, &pb.ReorderImagesRequest{LaptopId: laptopId, ImageIds: imageIds})
+	if err != nil {
+		return fmt.Errorf("cannot reorder images: %w", err)
+	}
+
+	return nil
+}
+
 func TestUploadImage(client pb.LaptopServiceClient) {
 	laptop := sample.NewLaptop()
 	CreateLaptop(client, laptop)
@@ -182,15 +390,115 @@ func SearchLaptop(client pb.LaptopServiceClient, filter *pb.FilterMessage) {
 			log.Fatal("cannot receive response", err)
 		}
 
-		laptop := res.GetLaptop()
+		laptops := res.GetLaptops()
+		if res.GetLaptop() != nil {
+			laptops = append(laptops, res.GetLaptop())
+		}
+
+		for _, laptop := range laptops {
+			printLaptop(laptop)
+		}
+	}
+}
+
+func printLaptop(laptop *pb.Laptop) {
+	log.Print("-- found: ", laptop.GetId())
+	log.Print("  + brand: ", laptop.GetBrand())
+	log.Print("  + name: ", laptop.GetName())
+	log.Print("  + cpu core: ", laptop.GetCpu().GetCoresMunber())
+	log.Print("  + cpu min freq: ", units.FormatFrequency(laptop.GetCpu().GetMinFreq()))
+	log.Print("  + ram: ", units.FormatMemory(laptop.GetMemory()))
+	log.Print("  + weight: ", units.FormatWeight(units.WeightKg(laptop)))
+	log.Print("  + price: ", units.FormatMoney(laptop.GetPrice()))
+	log.Print("  + converted price: ", units.FormatMoney(laptop.GetConvertedPrice()))
+	log.Print("  + performance score: ", laptop.GetPerformanceScore())
+	log.Print("  + value score: ", laptop.GetValueScore())
+}
+
+func ExplainSearch(client pb.LaptopServiceClient, filter *pb.FilterMessage, laptopId string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-		log.Print("-- found: ", laptop.GetId())
-		log.Print("  + brand: ", laptop.GetBrand())
-		log.Print("  + name: ", laptop.GetName())
-		log.Print("  + cpu core: ", laptop.GetCpu().GetCoresMunber())
-		log.Print("  + cpu min freq: ", laptop.Cpu.GetMinFreq())
-		log.Print("  + ram: ", laptop.GetMemory().GetValue())
-		log.Print("  + price: ", laptop.GetPriceUsd())
+	res, err := client.ExplainSearch(ctx, &pb.ExplainSearchRequest{
+		Filter:   filter,
+		LaptopId: laptopId,
+	})
+	if err != nil {
+		log.Fatal("cannot explain search: ", err)
+	}
+
+	for _, explanation := range res.GetExplanations() {
+		log.Printf("-- laptop %s qualified: %v", explanation.GetLaptopId(), explanation.GetQualified())
+		for _, clause := range explanation.GetClauses() {
+			log.Printf("  + %s passed: %v, %s", clause.GetClause(), clause.GetPassed(), clause.GetMessage())
+		}
+	}
+}
+
+func Suggest(client pb.LaptopServiceClient, field pb.SuggestRequest_Field, prefix string) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := client.Suggest(ctx, &pb.SuggestRequest{
+		Prefix: prefix,
+		Field:  field,
+	})
+	if err != nil {
+		log.Fatal("cannot get suggestions: ", err)
+	}
+
+	suggestions := make([]string, 0, len(res.GetSuggestions()))
+	for _, suggestion := range res.GetSuggestions() {
+		suggestions = append(suggestions, suggestion.GetText())
+	}
+
+	log.Printf("suggestions for %q: %v", prefix, suggestions)
+
+	return suggestions
+}
+
+func GetLaptop(client pb.LaptopServiceClient, laptopId string, paths ...string) *pb.Laptop {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := &pb.GetLaptopRequest{
+		Id: laptopId,
+	}
+	if len(paths) > 0 {
+		req.ReadMask = &fieldmaskpb.FieldMask{Paths: paths}
+	}
+
+	res, err := client.GetLaptop(ctx, req)
+	if err != nil {
+		log.Fatal("cannot get laptop: ", err)
+	}
+
+	printLaptop(res.GetLaptop())
+
+	return res.GetLaptop()
+}
+
+func CompareLaptops(client pb.LaptopServiceClient, laptopIds []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := client.CompareLaptops(ctx, &pb.CompareLaptopsRequest{
+		LaptopIds: laptopIds,
+	})
+	if err != nil {
+		log.Fatal("cannot compare laptops: ", err)
+	}
+
+	for _, laptop := range res.GetLaptops() {
+		log.Printf("-- laptop %s: %s %s", laptop.GetId(), laptop.GetBrand(), laptop.GetName())
+	}
+
+	for _, attr := range res.GetAttributes() {
+		if attr.GetAllEqual() {
+			log.Printf("  + %s (%s): %v, all equal", attr.GetName(), attr.GetUnit(), attr.GetValues())
+		} else {
+			log.Printf("  + %s (%s): %v, winner: %v", attr.GetName(), attr.GetUnit(), attr.GetValues(), attr.GetWinnerIds())
+		}
 	}
 }
 
@@ -77,8 +77,21 @@ func accessableRoles() map[string]bool {
 	const servicePath = "/LaptopService/"
 
 	return map[string]bool{
-		servicePath + "CreateLaptop": true,
-		servicePath + "UploadImage":  true,
-		servicePath + "RateLaptop":   true,
+		servicePath + "CreateLaptop":    true,
+		servicePath + "DeleteLaptop":    true,
+		servicePath + "UploadImage":     true,
+		servicePath + "StartUpload":     true,
+		servicePath + "UploadChunk":     true,
+		servicePath + "QueryUpload":     true,
+		servicePath + "FinishUpload":    true,
+		servicePath + "DeleteImage":     true,
+		servicePath + "SetPrimaryImage": true,
+		servicePath + "ReorderImages":   true,
+		servicePath + "DownloadImage":   true,
+		servicePath + "GetImageURL":     true,
+		servicePath + "GetImageGCStats": true,
+		servicePath + "RateLaptop":      true,
+		servicePath + "GetMyRating":     true,
+		servicePath + "DeleteMyRating":  true,
 	}
 }
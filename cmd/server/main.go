@@ -7,12 +7,18 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
 	"pc-book/pb"
 	"pc-book/service"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/reflection"
 )
 
@@ -24,6 +30,28 @@ const (
 func main() {
 	port := flag.Int("port", 0, "server port")
 	serverType := flag.String("server-type", "grpc", "type of server (grpc/rest)")
+	ratesFile := flag.String("rates-file", "exchange_rates.json", "exchange rate file, reloaded on SIGHUP")
+	maxImageWidth := flag.Int("max-image-width", service.DEFAULT_MAX_IMAGE_WIDTH, "maximum width of uploaded images in pixels")
+	maxImageHeight := flag.Int("max-image-height", service.DEFAULT_MAX_IMAGE_HEIGHT, "maximum height of uploaded images in pixels")
+	thumbnailSizes := flag.String("thumbnail-sizes", "128,512", "comma separated thumbnail sizes in pixels, empty to disable thumbnails")
+	maxImageSize := flag.Int64("max-image-size", service.DEFAULT_MAX_IMAGE_SIZE, "maximum size of uploaded images in bytes")
+	roleImageSizes := flag.String("role-image-sizes", "", "comma separated role=bytes upload size limits that override -max-image-size")
+	maxConcurrentUploads := flag.Int("max-concurrent-uploads", service.DEFAULT_MAX_CONCURRENT_UPLOADS, "maximum number of uploads processed at the same time")
+	uploadFolder := flag.String("upload-folder", service.DEFAULT_UPLOAD_FOLDER, "folder that keeps the partial resumable uploads")
+	uploadSweepInterval := flag.Duration("upload-sweep-interval", service.DEFAULT_UPLOAD_SWEEP_INTERVAL, "interval between removals of the expired resumable uploads")
+	stripImageMetadata := flag.Bool("strip-image-metadata", true, "remove the EXIF, XMP and other metadata from uploaded images")
+	scanCommand := flag.String("scan-command", "", "command that scans each uploaded image, given its path as the last argument, empty to disable scanning")
+	scanTimeout := flag.Duration("scan-timeout", service.DEFAULT_SCAN_TIMEOUT, "maximum duration of an image scan")
+	quarantineFolder := flag.String("quarantine-folder", service.DEFAULT_QUARANTINE_FOLDER, "folder that keeps the images quarantined by the scan command")
+	minRatingScore := flag.Float64("min-rating-score", service.DEFAULT_MIN_RATING_SCORE, "lowest score accepted by RateLaptop")
+	maxRatingScore := flag.Float64("max-rating-score", service.DEFAULT_MAX_RATING_SCORE, "highest score accepted by RateLaptop")
+	imageURLBase := flag.String("image-url-base", "", "scheme and host of the REST server prefixed to signed image urls")
+	imageURLSecret := flag.String("image-url-secret", "", "secret signing the image urls, shared by every server that issues or serves them; signed urls are disabled if empty")
+	imageURLTTL := flag.Duration("image-url-ttl", service.DEFAULT_IMAGE_URL_TTL, "lifetime of signed image urls")
+	gcOnce := flag.Bool("gc-once", false, "collect orphaned image files once, print the report and exit")
+	gcInterval := flag.Duration("gc-interval", service.DEFAULT_IMAGE_GC_INTERVAL, "interval between image garbage collections, 0 to disable")
+	gcGracePeriod := flag.Duration("gc-grace-period", service.DEFAULT_IMAGE_GC_GRACE_PERIOD, "minimum age of the orphaned images and files that are collected")
+	gcDelete := flag.Bool("gc-delete", false, "delete the orphaned images and files instead of only reporting them")
 	flag.Parse()
 
 	log.Printf("start server on port %d", *port)
@@ -39,9 +67,76 @@ func main() {
 	authInterceptor := service.NewAuthInterceptor(jwtManager, accessableRoles())
 
 	laptopStore := service.NewInMemoryLaptopStore()
-	imageStore := service.NewDiskImageStore("tmp")
+	sizes, err := parseSizes(*thumbnailSizes)
+	if err != nil {
+		log.Fatal("invalid thumbnail sizes: ", err)
+	}
+	imageStore, err := service.NewDiskImageStoreWithThumbnails("tmp", sizes)
+	if err != nil {
+		log.Fatal("cannot load image store: ", err)
+	}
+	if *gcOnce {
+		// laptops are only kept in memory by a running server, so only orphan files are collected here
+		collector := service.NewImageCollector(imageStore, nil, *gcGracePeriod, *gcDelete)
+		err := printImageGCReport(collector)
+		if err != nil {
+			log.Fatal("cannot collect image garbage: ", err)
+		}
+		return
+	}
 	ratingStore := service.NewInMemoryRatingStore()
-	laptopServer := service.NewLaptopServer(laptopStore, imageStore, ratingStore)
+	exchangeRates, err := service.NewFileExchangeRateStore(*ratesFile)
+	if err != nil {
+		log.Fatal("cannot load exchange rates: ", err)
+	}
+	reloadOnHangup(exchangeRates)
+
+	roleSizes, err := parseRoleSizes(*roleImageSizes)
+	if err != nil {
+		log.Fatal("invalid role image sizes: ", err)
+	}
+
+	if *maxImageSize < 1 {
+		log.Fatal("max image size must be positive")
+	}
+	if *maxConcurrentUploads < 1 {
+		log.Fatal("max concurrent uploads must be positive")
+	}
+	if *maxImageWidth < 1 || *maxImageHeight < 1 {
+		log.Fatal("max image width and height must be positive")
+	}
+	if !(*minRatingScore <= *maxRatingScore) {
+		log.Fatal("min rating score must not be greater than max rating score")
+	}
+
+	config := service.DefaultLaptopServerConfig()
+	config.MaxImageWidth = *maxImageWidth
+	config.MaxImageHeight = *maxImageHeight
+	config.MaxImageSize = *maxImageSize
+	config.RoleMaxImageSizes = roleSizes
+	config.MaxConcurrentUploads = *maxConcurrentUploads
+	config.UploadFolder = *uploadFolder
+	if *uploadSweepInterval <= 0 {
+		log.Fatal("upload sweep interval must be positive")
+	}
+	uploadSessions := service.NewDiskUploadSessionStore(config.UploadFolder, config.UploadSessionTTL)
+	go uploadSessions.Run(context.Background(), *uploadSweepInterval)
+	config.UploadSessions = uploadSessions
+	config.StripImageMetadata = *stripImageMetadata
+	if fields := strings.Fields(*scanCommand); len(fields) > 0 {
+		config.Scanner = service.NewCommandScanner(fields[0], fields[1:], *scanTimeout)
+	}
+	config.QuarantineFolder = *quarantineFolder
+	config.MinRatingScore = *minRatingScore
+	config.MaxRatingScore = *maxRatingScore
+	if *imageURLSecret != "" {
+		config.ImageURLSigner = service.NewImageURLSigner(*imageURLBase, []byte(*imageURLSecret), *imageURLTTL)
+	}
+	if *gcInterval > 0 {
+		config.ImageCollector = service.NewImageCollector(imageStore, laptopStore, *gcGracePeriod, *gcDelete)
+		go config.ImageCollector.Run(context.Background(), *gcInterval)
+	}
+	laptopServer := service.NewLaptopServerWithConfig(laptopStore, imageStore, ratingStore, exchangeRates, config)
 
 	if *serverType == "rest" {
 		err = runRestServer(jwtManager, authServer, laptopServer, authInterceptor, port)
@@ -56,23 +151,16 @@ func main() {
 func runRestServer(
 	jwtManager *service.JwtManager,
 	authServer pb.AuthServiceServer,
-	laptopServer pb.LaptopServiceServer,
+	laptopServer *service.LaptopServer,
 	authInterceptor *service.AuthInterceptor,
 	port *int,
 ) error {
-	mux := runtime.NewServeMux()
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	err := pb.RegisterAuthServiceHandlerServer(ctx, mux, authServer)
+	handler, stop, err := newRestHandler(authServer, laptopServer, authInterceptor)
 	if err != nil {
 		return err
 	}
+	defer stop()
 
-	err = pb.RegisterLaptopServiceHandlerServer(ctx, mux, laptopServer)
-	if err != nil {
-		return err
-	}
 	addr := fmt.Sprintf("0.0.0.0:%d", *port)
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
@@ -81,7 +169,47 @@ func runRestServer(
 
 	log.Printf("starting rest server at %s", listener.Addr().String())
 
-	return http.Serve(listener, mux)
+	return http.Serve(listener, handler)
+}
+
+// newRestHandler serves the REST gateway. The gateway calls an in-process gRPC server on a loopback
+// address, so that REST calls go through the same auth interceptors as gRPC calls.
+func newRestHandler(
+	authServer pb.AuthServiceServer,
+	laptopServer *service.LaptopServer,
+	authInterceptor *service.AuthInterceptor,
+) (http.Handler, func(), error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	grpcServer := newGrpcServer(authServer, laptopServer, authInterceptor)
+	go grpcServer.Serve(listener)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stop := func() {
+		cancel()
+		grpcServer.Stop()
+	}
+
+	mux := runtime.NewServeMux()
+	endpoint := listener.Addr().String()
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+
+	err = pb.RegisterAuthServiceHandlerFromEndpoint(ctx, mux, endpoint, opts)
+	if err == nil {
+		err = pb.RegisterLaptopServiceHandlerFromEndpoint(ctx, mux, endpoint, opts)
+	}
+	if err == nil {
+		err = service.NewImageHTTPHandler(laptopServer, authInterceptor).Register(mux)
+	}
+	if err != nil {
+		stop()
+		return nil, nil, err
+	}
+
+	return mux, stop, nil
 }
 
 func runGrpcServer(
@@ -91,6 +219,23 @@ func runGrpcServer(
 	authInterceptor *service.AuthInterceptor,
 	port *int,
 ) error {
+	grpcServer := newGrpcServer(authServer, laptopServer, authInterceptor)
+
+	addr := fmt.Sprintf("0.0.0.0:%d", *port)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatal("cannot start server: ", err)
+	}
+
+	log.Printf("starting grpc server at %s", listener.Addr().String())
+	return grpcServer.Serve(listener)
+}
+
+func newGrpcServer(
+	authServer pb.AuthServiceServer,
+	laptopServer pb.LaptopServiceServer,
+	authInterceptor *service.AuthInterceptor,
+) *grpc.Server {
 	grpcServer := grpc.NewServer(
 		grpc.ChainUnaryInterceptor(
 			authInterceptor.Unary(),
@@ -104,23 +249,105 @@ func runGrpcServer(
 	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
 	reflection.Register(grpcServer)
 
-	addr := fmt.Sprintf("0.0.0.0:%d", *port)
-	listener, err := net.Listen("tcp", addr)
+	return grpcServer
+}
+
+func reloadOnHangup(exchangeRates service.ExchangeRateStore) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	go func() {
+		for range hangup {
+			err := exchangeRates.Reload()
+			if err != nil {
+				log.Print("cannot reload exchange rates: ", err)
+				continue
+			}
+
+			log.Print("exchange rates reloaded")
+		}
+	}()
+}
+
+func printImageGCReport(collector *service.ImageCollector) error {
+	report, err := collector.Collect()
 	if err != nil {
-		log.Fatal("cannot start server: ", err)
+		return err
 	}
 
-	log.Printf("starting grpc server at %s", listener.Addr().String())
-	return grpcServer.Serve(listener)
+	for _, orphan := range report.Orphans {
+		fmt.Println(orphan)
+	}
+
+	fmt.Printf("scanned %d files: %d orphan files (%d bytes), %d deleted\n",
+		report.ScannedFiles, report.OrphanFiles, report.OrphanBytes, report.DeletedFiles)
+
+	return nil
+}
+
+func parseSizes(value string) ([]int, error) {
+	sizes := []int{}
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		size, err := strconv.Atoi(field)
+		if err != nil || size < 1 {
+			return nil, fmt.Errorf("invalid size %q", field)
+		}
+
+		sizes = append(sizes, size)
+	}
+
+	return sizes, nil
+}
+
+func parseRoleSizes(value string) (map[string]int64, error) {
+	sizes := make(map[string]int64)
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		role, size, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid role size %q", field)
+		}
+
+		bytes, err := strconv.ParseInt(size, 10, 64)
+		if err != nil || bytes < 1 {
+			return nil, fmt.Errorf("invalid role size %q", field)
+		}
+
+		sizes[role] = bytes
+	}
+
+	return sizes, nil
 }
 
 func accessableRoles() map[string][]string {
 	const servicePath = "/LaptopService/"
 
 	return map[string][]string{
-		servicePath + "CreateLaptop": {"admin"},
-		servicePath + "UploadImage":  {"admin"},
-		servicePath + "RateLaptop":   {"admin", "user"},
+		servicePath + "CreateLaptop":    {"admin"},
+		servicePath + "DeleteLaptop":    {"admin"},
+		servicePath + "UploadImage":     {"admin"},
+		servicePath + "StartUpload":     {"admin"},
+		servicePath + "UploadChunk":     {"admin"},
+		servicePath + "QueryUpload":     {"admin"},
+		servicePath + "FinishUpload":    {"admin"},
+		servicePath + "DeleteImage":     {"admin"},
+		servicePath + "SetPrimaryImage": {"admin"},
+		servicePath + "ReorderImages":   {"admin"},
+		servicePath + "DownloadImage":   {"admin", "user"},
+		servicePath + "GetImageURL":     {"admin"},
+		servicePath + "GetImageGCStats": {"admin"},
+		servicePath + "RateLaptop":      {"admin", "user"},
+		servicePath + "GetMyRating":     {"admin", "user"},
+		servicePath + "DeleteMyRating":  {"admin", "user"},
 	}
 }
 
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"pc-book/sample"
+	"pc-book/service"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// startRestServer serves the REST gateway with the seeded users and the roles of the real server.
+func startRestServer(t *testing.T) (*httptest.Server, service.LaptopStore, *service.DiskImageStore) {
+	userStore := service.NewInMemoryUserStore()
+	require.NoError(t, seedUser(userStore))
+
+	jwtManager := service.NewJwtManager(JWT_SECRET_KEY, JWT_DURATION)
+	authServer := service.NewAuthServer(userStore, *jwtManager)
+	authInterceptor := service.NewAuthInterceptor(jwtManager, accessableRoles())
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	imageStore, err := service.NewDiskImageStore(t.TempDir())
+	require.NoError(t, err)
+
+	config := service.DefaultLaptopServerConfig()
+	config.UploadFolder = t.TempDir()
+	config.ImageURLSigner = service.NewImageURLSigner("", []byte("secret"), time.Minute)
+	laptopServer := service.NewLaptopServerWithConfig(laptopStore, imageStore, service.NewInMemoryRatingStore(), nil, config)
+
+	handler, stop, err := newRestHandler(authServer, laptopServer, authInterceptor)
+	require.NoError(t, err)
+	t.Cleanup(stop)
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	return server, laptopStore, imageStore
+}
+
+// login returns an access token of a seeded user.
+func login(t *testing.T, serverURL, username string) string {
+	res, err := http.Post(serverURL+"/v1/auth/login", "application/json",
+		strings.NewReader(`{"username": "`+username+`", "password": "secret"}`))
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	body := map[string]string{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+	require.NotEmpty(t, body["accessToken"])
+
+	return body["accessToken"]
+}
+
+func doRequest(t *testing.T, method, url, accessToken string) int {
+	code, _ := doRequestBody(t, method, url, accessToken)
+	return code
+}
+
+func doRequestBody(t *testing.T, method, url, accessToken string) (int, []byte) {
+	req, err := http.NewRequest(method, url, nil)
+	require.NoError(t, err)
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	return res.StatusCode, body
+}
+
+func TestRestGatewayAuthorization(t *testing.T) {
+	t.Parallel()
+
+	server, laptopStore, _ := startRestServer(t)
+
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopStore.Save(laptop))
+
+	require.Equal(t, http.StatusUnauthorized, doRequest(t, http.MethodDelete, server.URL+"/v1/laptop/"+laptop.Id, ""))
+	require.Equal(t, http.StatusUnauthorized, doRequest(t, http.MethodDelete, server.URL+"/v1/laptop/image/unknown", ""))
+	require.Equal(t, http.StatusUnauthorized, doRequest(t, http.MethodGet, server.URL+"/v1/admin/image_gc", ""))
+
+	userToken := login(t, server.URL, "user1")
+	require.Equal(t, http.StatusForbidden, doRequest(t, http.MethodDelete, server.URL+"/v1/laptop/"+laptop.Id, userToken))
+
+	found, err := laptopStore.Find(laptop.Id)
+	require.NoError(t, err)
+	require.NotNil(t, found)
+
+	// public RPCs need no token
+	require.Equal(t, http.StatusOK, doRequest(t, http.MethodGet, server.URL+"/v1/laptop/"+laptop.Id, ""))
+
+	adminToken := login(t, server.URL, "admin")
+	require.Equal(t, http.StatusOK, doRequest(t, http.MethodDelete, server.URL+"/v1/laptop/"+laptop.Id, adminToken))
+
+	found, err = laptopStore.Find(laptop.Id)
+	require.NoError(t, err)
+	require.Nil(t, found)
+}
+
+func TestRestImageDownloadAuthorization(t *testing.T) {
+	t.Parallel()
+
+	server, _, imageStore := startRestServer(t)
+
+	imageData := []byte("image data")
+	imageId, err := imageStore.Save(&service.ImageInfo{LaptopId: "laptop-1", Type: ".jpg", MimeType: "image/jpeg"}, bytes.NewReader(imageData))
+	require.NoError(t, err)
+
+	imageURL := server.URL + "/v1/images/" + imageId
+	require.Equal(t, http.StatusUnauthorized, doRequest(t, http.MethodGet, imageURL, ""))
+	require.Equal(t, http.StatusUnauthorized, doRequest(t, http.MethodGet, imageURL+"/url", ""))
+
+	userToken := login(t, server.URL, "user1")
+	code, body := doRequestBody(t, http.MethodGet, imageURL, userToken)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, imageData, body)
+	require.Equal(t, http.StatusForbidden, doRequest(t, http.MethodGet, imageURL+"/url", userToken))
+
+	adminToken := login(t, server.URL, "admin")
+	code, body = doRequestBody(t, http.MethodGet, imageURL+"/url", adminToken)
+	require.Equal(t, http.StatusOK, code)
+
+	signed := map[string]string{}
+	require.NoError(t, json.Unmarshal(body, &signed))
+	require.NotEmpty(t, signed["url"])
+
+	code, body = doRequestBody(t, http.MethodGet, server.URL+signed["url"], "")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, imageData, body)
+}
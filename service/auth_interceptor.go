@@ -3,6 +3,8 @@ package service
 import (
 	"context"
 	"log"
+	"net/http"
+	"strings"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -10,6 +12,8 @@ import (
 	"google.golang.org/grpc/status"
 )
 
+type claimsContextKey struct{}
+
 type AuthInterceptor struct {
 	jwt             *JwtManager
 	accessableRoles map[string][]string
@@ -26,12 +30,12 @@ func (interceptor *AuthInterceptor) Unary() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
 		log.Print("---> unaryInterceptor: ", info.FullMethod)
 
-		err = interceptor.authorize(ctx, info.FullMethod)
+		claims, err := interceptor.authorize(ctx, info.FullMethod)
 		if err != nil {
 			return nil, err
 		}
 
-		return handler(ctx, req)
+		return handler(contextWithClaims(ctx, claims), req)
 	}
 }
 
@@ -39,43 +43,91 @@ func (interceptor *AuthInterceptor) Stream() grpc.StreamServerInterceptor {
 	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
 		log.Print("---> streamInterceptor: ", info.FullMethod)
 
-		err := interceptor.authorize(ss.Context(), info.FullMethod)
+		claims, err := interceptor.authorize(ss.Context(), info.FullMethod)
 		if err != nil {
 			return err
 		}
 
-		return handler(srv, ss)
+		return handler(srv, &authServerStream{
+			ServerStream: ss,
+			ctx:          contextWithClaims(ss.Context(), claims),
+		})
+	}
+}
+
+// HTTPContext authorizes an HTTP request to the endpoint standing for method, the same way
+// as a call to method. The access token is read from the Authorization header, with or without
+// a Bearer prefix. The returned context carries the claims of the caller.
+func (interceptor *AuthInterceptor) HTTPContext(r *http.Request, method string) (context.Context, error) {
+	ctx := r.Context()
+
+	accessToken := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if accessToken != "" {
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", accessToken))
 	}
+
+	claims, err := interceptor.authorize(ctx, method)
+	if err != nil {
+		return nil, err
+	}
+
+	return contextWithClaims(r.Context(), claims), nil
+}
+
+// authServerStream passes the claims of the caller to the stream handler through its context.
+type authServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (stream *authServerStream) Context() context.Context {
+	return stream.ctx
+}
+
+// ClaimsFromContext returns the claims of the access token that authorized the call.
+// It returns false when the RPC does not require authorization.
+func ClaimsFromContext(ctx context.Context) (*UserClaims, bool) {
+	claims, ok := ctx.Value(claimsContextKey{}).(*UserClaims)
+	return claims, ok
+}
+
+func contextWithClaims(ctx context.Context, claims *UserClaims) context.Context {
+	if claims == nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, claimsContextKey{}, claims)
 }
 
-func (interceptor *AuthInterceptor) authorize(ctx context.Context, method string) error {
+func (interceptor *AuthInterceptor) authorize(ctx context.Context, method string) (*UserClaims, error) {
 	accessableRoles, ok := interceptor.accessableRoles[method]
 	if !ok {
-		return nil
+		return nil, nil
 	}
 
 	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
-		return status.Errorf(codes.Unauthenticated, "metadata is not provided")
+		return nil, status.Errorf(codes.Unauthenticated, "metadata is not provided")
 	}
 
 	values := md["authorization"]
 	if len(values) == 0 {
-		return status.Errorf(codes.Unauthenticated, "auth token is not provided")
+		return nil, status.Errorf(codes.Unauthenticated, "auth token is not provided")
 	}
 
-	accessToken := values[0]
+	// the REST gateway forwards the Authorization header as is
+	accessToken := strings.TrimPrefix(values[0], "Bearer ")
 	claims, err := interceptor.jwt.Verify(accessToken)
 	if err != nil {
-		return status.Errorf(codes.Unauthenticated, "access token is invalid")
+		return nil, status.Errorf(codes.Unauthenticated, "access token is invalid")
 	}
 
 	for _, role := range accessableRoles {
 		if role == claims.Role {
-			return nil
+			return claims, nil
 		}
 	}
 
-	return status.Errorf(codes.PermissionDenied, "no permission to access this RPC")
+	return nil, status.Errorf(codes.PermissionDenied, "no permission to access this RPC")
 
 }
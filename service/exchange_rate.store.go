@@ -0,0 +1,99 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"pc-book/pb"
+	"pc-book/units"
+	"strings"
+	"sync"
+)
+
+var ErrUnknownCurrency = errors.New("unknown currency")
+
+type ExchangeRateStore interface {
+	Convert(amount *pb.Money, currencyCode string) (*pb.Money, error)
+	Reload() error
+}
+
+// exchangeRateFile is the format of the exchange-rate file.
+// Rates are the amount of each currency that one unit of the base currency buys.
+type exchangeRateFile struct {
+	Base  string             `json:"base"`
+	Rates map[string]float64 `json:"rates"`
+}
+
+type FileExchangeRateStore struct {
+	mutex    sync.RWMutex
+	filename string
+	rates    map[string]float64
+}
+
+func NewFileExchangeRateStore(filename string) (*FileExchangeRateStore, error) {
+	store := &FileExchangeRateStore{
+		filename: filename,
+	}
+
+	err := store.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// Reload implements ExchangeRateStore.
+func (store *FileExchangeRateStore) Reload() error {
+	data, err := os.ReadFile(store.filename)
+	if err != nil {
+		return fmt.Errorf("cannot read exchange rate file: %w", err)
+	}
+
+	file := exchangeRateFile{}
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return fmt.Errorf("cannot parse exchange rate file: %w", err)
+	}
+
+	rates := make(map[string]float64)
+	rates[strings.ToUpper(file.Base)] = 1
+	for code, rate := range file.Rates {
+		if rate <= 0 {
+			return fmt.Errorf("invalid exchange rate for %s: %v", code, rate)
+		}
+		rates[strings.ToUpper(code)] = rate
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.rates = rates
+
+	return nil
+}
+
+// Convert implements ExchangeRateStore.
+func (store *FileExchangeRateStore) Convert(amount *pb.Money, currencyCode string) (*pb.Money, error) {
+	from := strings.ToUpper(amount.GetCurrencyCode())
+	to := strings.ToUpper(currencyCode)
+	if from == to {
+		return units.NewMoney(to, units.MoneyToFloat(amount)), nil
+	}
+
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	fromRate, ok := store.rates[from]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownCurrency, from)
+	}
+
+	toRate, ok := store.rates[to]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownCurrency, to)
+	}
+
+	return units.NewMoney(to, units.MoneyToFloat(amount)/fromRate*toRate), nil
+}
@@ -0,0 +1,262 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// IMAGE_BLOB_FOLDER is the folder inside the image folder that holds the image contents,
+// keyed by their SHA-256 checksum.
+const IMAGE_BLOB_FOLDER = "blobs"
+
+var ErrImageChecksumMismatch = errors.New("image checksum mismatch")
+
+// imageBlob is the content shared by all the images with the same checksum.
+type imageBlob struct {
+	refs     int
+	variants []*ImageVariant
+}
+
+// blobPath returns the path of the content with the given checksum,
+// sharded into two levels of folders by its first four hex digits.
+func (imgStore *DiskImageStore) blobPath(checksum string) string {
+	return filepath.Join(imgStore.imageFolder, IMAGE_BLOB_FOLDER, checksum[:2], checksum[2:4], checksum)
+}
+
+func (imgStore *DiskImageStore) blobVariantPath(checksum, variant string) string {
+	return imgStore.blobPath(checksum) + "_" + variant
+}
+
+// receiveBlob streams imageData into a temp file in the blob folder, returning its path,
+// checksum and size. The caller must remove the temp file.
+func (imgStore *DiskImageStore) receiveBlob(imageData io.Reader) (string, string, uint64, error) {
+	folder := filepath.Join(imgStore.imageFolder, IMAGE_BLOB_FOLDER)
+
+	err := os.MkdirAll(folder, 0755)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("cannot create image blob folder: %w", err)
+	}
+
+	file, err := os.CreateTemp(folder, "receive.*.tmp")
+	if err != nil {
+		return "", "", 0, fmt.Errorf("cannot create image file: %w", err)
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hash), imageData)
+	if err == nil {
+		err = file.Chmod(0644)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", "", 0, fmt.Errorf("cannot write image to file: %w", err)
+	}
+
+	return file.Name(), hex.EncodeToString(hash.Sum(nil)), uint64(size), nil
+}
+
+// hashFile returns the checksum and size of the file at path.
+func hashFile(path string) (string, uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, fmt.Errorf("cannot open image file: %w", err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return "", 0, fmt.Errorf("cannot read image file: %w", err)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), uint64(size), nil
+}
+
+// writeBlob links the file at tempPath into the blob of checksum and writes its thumbnails.
+// The link is atomic, and linking a blob that already exists keeps the existing one,
+// since it has the same content. The blob is only removed on failure if this call linked it.
+func (imgStore *DiskImageStore) writeBlob(checksum string, tempPath string) ([]*ImageVariant, error) {
+	path := imgStore.blobPath(checksum)
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create image blob folder: %w", err)
+	}
+
+	err = os.Link(tempPath, path)
+	if err != nil && !os.IsExist(err) {
+		// a file on another file system cannot be linked, so it is copied next to the blob first
+		err = imgStore.copyBlob(tempPath, path)
+		if err != nil && !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+	}
+	linked := err == nil
+
+	variants, err := imgStore.writeThumbnails(checksum)
+	if err != nil {
+		// a blob linked by another save is still in use by that save
+		if linked {
+			os.Remove(path)
+		}
+		return nil, err
+	}
+
+	return variants, nil
+}
+
+// copyBlob copies the file at tempPath into the blob folder and links the copy to path.
+func (imgStore *DiskImageStore) copyBlob(tempPath, path string) error {
+	file, err := os.Open(tempPath)
+	if err != nil {
+		return fmt.Errorf("cannot open image file: %w", err)
+	}
+	defer file.Close()
+
+	copyPath, _, _, err := imgStore.receiveBlob(file)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(copyPath)
+
+	err = os.Link(copyPath, path)
+	if err != nil {
+		return fmt.Errorf("cannot write image to file: %w", err)
+	}
+
+	return nil
+}
+
+// retain adds a reference from image to its blob. The caller must hold the lock.
+func (imgStore *DiskImageStore) retain(image *ImageInfo) {
+	blob := imgStore.blobs[image.Checksum]
+	if blob == nil {
+		blob = &imageBlob{variants: image.Variants}
+		imgStore.blobs[image.Checksum] = blob
+	}
+
+	blob.refs++
+}
+
+// release drops the reference from image to its blob, and deletes the blob
+// once no image references it anymore. The caller must hold the lock.
+func (imgStore *DiskImageStore) release(image *ImageInfo) error {
+	blob := imgStore.blobs[image.Checksum]
+	if blob == nil {
+		return nil
+	}
+
+	blob.refs--
+	if blob.refs > 0 {
+		return nil
+	}
+
+	delete(imgStore.blobs, image.Checksum)
+	removeVariants(blob.variants)
+
+	path := imgStore.blobPath(image.Checksum)
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot delete image file: %w", err)
+	}
+
+	// the shard folders are only removed once they are empty
+	os.Remove(filepath.Dir(path))
+	os.Remove(filepath.Dir(filepath.Dir(path)))
+
+	return nil
+}
+
+// migrateLegacyFiles moves the files of an image stored before the blob layout,
+// named after the image id, to the blob of its checksum. It returns the names of the moved files.
+func (imgStore *DiskImageStore) migrateLegacyFiles(image *ImageInfo) []string {
+	legacyPath := filepath.Join(imgStore.imageFolder, image.Id+image.Type)
+	if _, err := os.Stat(legacyPath); err != nil {
+		return nil
+	}
+
+	err := os.MkdirAll(filepath.Dir(image.Path), 0755)
+	if err != nil {
+		log.Printf("cannot create image blob folder: %v", err)
+		return nil
+	}
+
+	moved := []string{}
+	err = os.Rename(legacyPath, image.Path)
+	if err != nil {
+		log.Printf("cannot move image file %s: %v", legacyPath, err)
+		return nil
+	}
+	moved = append(moved, filepath.Base(legacyPath))
+
+	for _, variant := range image.Variants {
+		legacyVariantPath := filepath.Join(imgStore.imageFolder, image.Id+"_"+variant.Name+image.Type)
+		err := os.Rename(legacyVariantPath, imgStore.blobVariantPath(image.Checksum, variant.Name))
+		if err == nil {
+			moved = append(moved, filepath.Base(legacyVariantPath))
+		}
+	}
+
+	return moved
+}
+
+// unattributedBlobs returns the files in the blob folder that no image references.
+func (imgStore *DiskImageStore) unattributedBlobs() []string {
+	unattributed := []string{}
+
+	root := filepath.Join(imgStore.imageFolder, IMAGE_BLOB_FOLDER)
+	filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+
+		checksum, _, _ := strings.Cut(entry.Name(), "_")
+		if imgStore.blobs[checksum] == nil {
+			unattributed = append(unattributed, path)
+		}
+
+		return nil
+	})
+
+	return unattributed
+}
+
+// writeFileAtomic writes data to a temp file next to path and renames it into place,
+// so readers never see a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Chmod(0644)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	err = os.Rename(file.Name(), path)
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	return nil
+}
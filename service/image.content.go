@@ -0,0 +1,100 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	DEFAULT_MAX_IMAGE_WIDTH  = 8192
+	DEFAULT_MAX_IMAGE_HEIGHT = 8192
+
+	// SNIFF_LENGTH is the number of bytes http.DetectContentType looks at.
+	SNIFF_LENGTH = 512
+)
+
+var (
+	ErrUnsupportedImageType = errors.New("unsupported image type")
+	ErrImageTypeMismatch    = errors.New("image type does not match its content")
+	ErrImageTooLarge        = errors.New("image resolution is too large")
+)
+
+// supportedImageTypes maps each accepted MIME type to the file extensions it can be uploaded with.
+var supportedImageTypes = map[string][]string{
+	"image/jpeg": {".jpg", ".jpeg"},
+	"image/png":  {".png"},
+}
+
+// ImageContent is what an ImageValidator verified about the bytes of an image.
+type ImageContent struct {
+	MimeType string
+	Width    int
+	Height   int
+}
+
+type ImageValidator struct {
+	maxWidth  int
+	maxHeight int
+}
+
+func NewImageValidator(maxWidth, maxHeight int) *ImageValidator {
+	return &ImageValidator{
+		maxWidth:  maxWidth,
+		maxHeight: maxHeight,
+	}
+}
+
+// Validate sniffs the content type of data from its magic bytes and decodes its header.
+// It fails if the content is not a supported image, does not match imageType,
+// or exceeds the maximum resolution. Only the beginning of data is read.
+func (validator *ImageValidator) Validate(imageType string, data io.ReadSeeker) (*ImageContent, error) {
+	header := make([]byte, SNIFF_LENGTH)
+	n, err := io.ReadFull(data, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("cannot read image: %w", err)
+	}
+
+	mimeType := http.DetectContentType(header[:n])
+
+	extensions, ok := supportedImageTypes[mimeType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedImageType, mimeType)
+	}
+
+	matched := false
+	for _, extension := range extensions {
+		if strings.EqualFold(extension, imageType) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return nil, fmt.Errorf("%w: %q is %s", ErrImageTypeMismatch, imageType, mimeType)
+	}
+
+	_, err = data.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, fmt.Errorf("cannot rewind image: %w", err)
+	}
+
+	config, _, err := image.DecodeConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: cannot decode %s header: %v", ErrUnsupportedImageType, mimeType, err)
+	}
+
+	if config.Width > validator.maxWidth || config.Height > validator.maxHeight {
+		return nil, fmt.Errorf("%w: %dx%d exceeds %dx%d", ErrImageTooLarge, config.Width, config.Height, validator.maxWidth, validator.maxHeight)
+	}
+
+	return &ImageContent{
+		MimeType: mimeType,
+		Width:    config.Width,
+		Height:   config.Height,
+	}, nil
+}
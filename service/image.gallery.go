@@ -0,0 +1,215 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+var (
+	ErrImageNotFound     = errors.New("image not found")
+	ErrInvalidImageOrder = errors.New("invalid image order")
+)
+
+// List implements ImageStore.
+// Images are returned in gallery order.
+func (imgStore *DiskImageStore) List(laptopId string) ([]*ImageInfo, error) {
+	imgStore.mutex.RLock()
+	defer imgStore.mutex.RUnlock()
+
+	return cloneImages(imgStore.gallery(laptopId)), nil
+}
+
+// Primary implements ImageStore.
+// It returns nil when the laptop has no images.
+func (imgStore *DiskImageStore) Primary(laptopId string) (*ImageInfo, error) {
+	imgStore.mutex.RLock()
+	defer imgStore.mutex.RUnlock()
+
+	image := primaryImage(imgStore.gallery(laptopId))
+	if image == nil {
+		return nil, nil
+	}
+
+	return image.Clone(), nil
+}
+
+// Delete implements ImageStore.
+// When the primary image is deleted, the next image in the gallery becomes primary.
+func (imgStore *DiskImageStore) Delete(imageId string) error {
+	imgStore.mutex.Lock()
+	defer imgStore.mutex.Unlock()
+
+	image := imgStore.images[imageId]
+	if image == nil {
+		return fmt.Errorf("%w: %s", ErrImageNotFound, imageId)
+	}
+
+	err := os.Remove(imgStore.metadataPath(image.Id))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot delete image metadata: %w", err)
+	}
+
+	delete(imgStore.images, imageId)
+	gallery := imgStore.removeFromGallery(image)
+
+	err = imgStore.release(image)
+	if err != nil {
+		return err
+	}
+
+	if image.Primary && len(gallery) > 0 {
+		gallery[0].Primary = true
+	}
+
+	return imgStore.updateGallery(gallery)
+}
+
+// DeleteLaptop implements ImageStore.
+// It removes every image of a laptop and returns how many were removed.
+func (imgStore *DiskImageStore) DeleteLaptop(laptopId string) (int, error) {
+	imgStore.mutex.Lock()
+	defer imgStore.mutex.Unlock()
+
+	gallery := imgStore.gallery(laptopId)
+	for i, image := range gallery {
+		err := os.Remove(imgStore.metadataPath(image.Id))
+		if err != nil && !os.IsNotExist(err) {
+			return i, fmt.Errorf("cannot delete image metadata: %w", err)
+		}
+
+		delete(imgStore.images, image.Id)
+		imgStore.removeFromGallery(image)
+
+		err = imgStore.release(image)
+		if err != nil {
+			return i + 1, err
+		}
+	}
+
+	return len(gallery), nil
+}
+
+// SetPrimary implements ImageStore.
+func (imgStore *DiskImageStore) SetPrimary(laptopId, imageId string) error {
+	imgStore.mutex.Lock()
+	defer imgStore.mutex.Unlock()
+
+	image := imgStore.images[imageId]
+	if image == nil || image.LaptopId != laptopId {
+		return fmt.Errorf("%w: %s", ErrImageNotFound, imageId)
+	}
+
+	gallery := imgStore.gallery(laptopId)
+	for _, other := range gallery {
+		other.Primary = other.Id == imageId
+	}
+
+	return imgStore.updateGallery(gallery)
+}
+
+// Reorder implements ImageStore.
+// imageIds must list every image of the laptop exactly once.
+func (imgStore *DiskImageStore) Reorder(laptopId string, imageIds []string) ([]*ImageInfo, error) {
+	imgStore.mutex.Lock()
+	defer imgStore.mutex.Unlock()
+
+	gallery := imgStore.gallery(laptopId)
+	if len(imageIds) != len(gallery) {
+		return nil, fmt.Errorf("%w: expected %d image ids, got %d", ErrInvalidImageOrder, len(gallery), len(imageIds))
+	}
+
+	positions := make(map[string]uint32)
+	for i, imageId := range imageIds {
+		image := imgStore.images[imageId]
+		if image == nil || image.LaptopId != laptopId {
+			return nil, fmt.Errorf("%w: image %s does not belong to laptop %s", ErrInvalidImageOrder, imageId, laptopId)
+		}
+		if _, ok := positions[imageId]; ok {
+			return nil, fmt.Errorf("%w: duplicate image %s", ErrInvalidImageOrder, imageId)
+		}
+
+		positions[imageId] = uint32(i)
+	}
+
+	for _, image := range gallery {
+		image.Position = positions[image.Id]
+	}
+
+	sortGallery(gallery)
+
+	err := imgStore.updateGallery(gallery)
+	if err != nil {
+		return nil, err
+	}
+
+	return cloneImages(gallery), nil
+}
+
+// gallery returns the images of a laptop in gallery order. The caller must hold the lock,
+// and may reorder the returned slice in place but not append to it.
+func (imgStore *DiskImageStore) gallery(laptopId string) []*ImageInfo {
+	return imgStore.galleries[laptopId]
+}
+
+// removeFromGallery removes an image from the gallery of its laptop and returns the remaining images.
+// The caller must hold the lock.
+func (imgStore *DiskImageStore) removeFromGallery(image *ImageInfo) []*ImageInfo {
+	gallery := []*ImageInfo{}
+	for _, other := range imgStore.galleries[image.LaptopId] {
+		if other != image {
+			gallery = append(gallery, other)
+		}
+	}
+
+	if len(gallery) == 0 {
+		delete(imgStore.galleries, image.LaptopId)
+	} else {
+		imgStore.galleries[image.LaptopId] = gallery
+	}
+
+	return gallery
+}
+
+// updateGallery renumbers the gallery positions and persists the metadata of every image.
+func (imgStore *DiskImageStore) updateGallery(gallery []*ImageInfo) error {
+	for i, image := range gallery {
+		image.Position = uint32(i)
+
+		err := imgStore.writeMetadata(image)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func sortGallery(gallery []*ImageInfo) {
+	sort.SliceStable(gallery, func(i, j int) bool {
+		if gallery[i].Position != gallery[j].Position {
+			return gallery[i].Position < gallery[j].Position
+		}
+		return gallery[i].Id < gallery[j].Id
+	})
+}
+
+func primaryImage(gallery []*ImageInfo) *ImageInfo {
+	for _, image := range gallery {
+		if image.Primary {
+			return image
+		}
+	}
+
+	return nil
+}
+
+func cloneImages(images []*ImageInfo) []*ImageInfo {
+	others := make([]*ImageInfo, len(images))
+	for i, image := range images {
+		others[i] = image.Clone()
+	}
+
+	return others
+}
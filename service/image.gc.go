@@ -0,0 +1,344 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_IMAGE_GC_INTERVAL     = time.Hour
+	DEFAULT_IMAGE_GC_GRACE_PERIOD = 24 * time.Hour
+)
+
+// ImageGCReport holds the counts of one garbage collection of the image folder.
+type ImageGCReport struct {
+	StartedAt     time.Time
+	Duration      time.Duration
+	Deleting      bool
+	ScannedFiles  int
+	OrphanFiles   int
+	OrphanBytes   int64
+	OrphanImages  int
+	DeletedFiles  int
+	DeletedImages int
+	Orphans       []string
+}
+
+// ImageCollector finds the files in the image folder that no image references, and the images
+// of laptops that no longer exist. Only orphans older than the grace period are reported,
+// so uploads in progress are left alone. Orphans are deleted only when deleting is enabled.
+//
+// Laptops are not persisted, so a laptop missing from the laptop store after a restart may still
+// exist. An image is thus only orphaned when its laptop was reported deleted by LaptopDeleted
+// during the lifetime of the collector. Without a laptop store, only orphan files are collected.
+type ImageCollector struct {
+	mutex       sync.Mutex
+	imageStore  *DiskImageStore
+	laptopStore LaptopStore
+	gracePeriod time.Duration
+	delete      bool
+	last        *ImageGCReport
+
+	deletedMutex   sync.Mutex
+	deletedLaptops map[string]time.Time
+}
+
+func NewImageCollector(imageStore *DiskImageStore, laptopStore LaptopStore, gracePeriod time.Duration, delete bool) *ImageCollector {
+	return &ImageCollector{
+		imageStore:  imageStore,
+		laptopStore: laptopStore,
+		gracePeriod: gracePeriod,
+		delete:      delete,
+
+		deletedLaptops: make(map[string]time.Time),
+	}
+}
+
+// LaptopDeleted records that a laptop was deleted, so that the images it left behind,
+// such as the ones of an upload that raced with the deletion, are collected.
+func (collector *ImageCollector) LaptopDeleted(laptopId string) {
+	collector.deletedMutex.Lock()
+	defer collector.deletedMutex.Unlock()
+
+	collector.deletedLaptops[laptopId] = time.Now()
+}
+
+// Run collects garbage every interval until ctx is done.
+func (collector *ImageCollector) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		report, err := collector.Collect()
+		if err != nil {
+			log.Print("cannot collect image garbage: ", err)
+			continue
+		}
+
+		log.Printf("image gc: %d orphan files (%d bytes), %d orphan images, %d files and %d images deleted",
+			report.OrphanFiles, report.OrphanBytes, report.OrphanImages, report.DeletedFiles, report.DeletedImages)
+	}
+}
+
+// Collect runs one garbage collection. Collections do not overlap.
+func (collector *ImageCollector) Collect() (*ImageGCReport, error) {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+
+	report := &ImageGCReport{
+		StartedAt: time.Now(),
+		Deleting:  collector.delete,
+	}
+	cutoff := report.StartedAt.Add(-collector.gracePeriod)
+
+	files, err := collector.imageStore.orphanFiles(cutoff)
+	if err != nil {
+		return nil, err
+	}
+
+	report.ScannedFiles = files.scanned
+	for _, file := range files.orphans {
+		report.OrphanFiles++
+		report.OrphanBytes += file.size
+		report.Orphans = append(report.Orphans, file.path)
+
+		if collector.delete && collector.imageStore.removeOrphanFile(file.path) {
+			report.DeletedFiles++
+		}
+	}
+
+	deletedLaptops := collector.deletedLaptopsBefore(cutoff)
+	for _, image := range collector.orphanImageCandidates(cutoff) {
+		if !deletedLaptops[image.LaptopId] {
+			continue
+		}
+
+		laptop, err := collector.laptopStore.Find(image.LaptopId)
+		if err != nil {
+			return nil, fmt.Errorf("cannot find laptop: %w", err)
+		}
+		if laptop != nil {
+			continue
+		}
+
+		report.OrphanImages++
+		report.Orphans = append(report.Orphans, collector.imageStore.metadataPath(image.Id))
+
+		if collector.delete {
+			err := collector.imageStore.Delete(image.Id)
+			if err != nil {
+				log.Printf("cannot delete orphan image %s: %v", image.Id, err)
+				continue
+			}
+			report.DeletedImages++
+		}
+	}
+
+	collector.forgetDeletedLaptops(deletedLaptops)
+
+	report.Duration = time.Since(report.StartedAt)
+	collector.last = report
+
+	return report, nil
+}
+
+func (collector *ImageCollector) orphanImageCandidates(cutoff time.Time) []*ImageInfo {
+	if collector.laptopStore == nil {
+		return nil
+	}
+
+	return collector.imageStore.imagesCreatedBefore(cutoff)
+}
+
+// deletedLaptopsBefore returns the laptops reported deleted before cutoff.
+func (collector *ImageCollector) deletedLaptopsBefore(cutoff time.Time) map[string]bool {
+	collector.deletedMutex.Lock()
+	defer collector.deletedMutex.Unlock()
+
+	laptops := make(map[string]bool)
+	for laptopId, deletedAt := range collector.deletedLaptops {
+		if deletedAt.Before(cutoff) {
+			laptops[laptopId] = true
+		}
+	}
+
+	return laptops
+}
+
+// forgetDeletedLaptops stops tracking the deleted laptops that have no images left.
+func (collector *ImageCollector) forgetDeletedLaptops(laptops map[string]bool) {
+	collector.deletedMutex.Lock()
+	defer collector.deletedMutex.Unlock()
+
+	for laptopId := range laptops {
+		images, err := collector.imageStore.List(laptopId)
+		if err == nil && len(images) == 0 {
+			delete(collector.deletedLaptops, laptopId)
+		}
+	}
+}
+
+// LastReport returns the report of the latest collection, or nil if none has run yet.
+func (collector *ImageCollector) LastReport() *ImageGCReport {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+
+	return collector.last
+}
+
+type orphanFile struct {
+	path string
+	size int64
+}
+
+type orphanFiles struct {
+	scanned int
+	orphans []orphanFile
+}
+
+// orphanFiles lists the files of the image folder that the store wrote but no longer references,
+// and that were last modified before cutoff. Files the store does not write are ignored.
+func (imgStore *DiskImageStore) orphanFiles(cutoff time.Time) (*orphanFiles, error) {
+	imgStore.mutex.RLock()
+	defer imgStore.mutex.RUnlock()
+
+	files := &orphanFiles{}
+	err := filepath.WalkDir(imgStore.imageFolder, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path != imgStore.imageFolder && path != filepath.Join(imgStore.imageFolder, IMAGE_BLOB_FOLDER) &&
+				!strings.HasPrefix(path, filepath.Join(imgStore.imageFolder, IMAGE_BLOB_FOLDER)+string(filepath.Separator)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		owned, referenced := imgStore.classify(path)
+		if !owned {
+			return nil
+		}
+
+		files.scanned++
+		if referenced {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			return nil
+		}
+
+		files.orphans = append(files.orphans, orphanFile{path: path, size: info.Size()})
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot scan image folder: %w", err)
+	}
+
+	return files, nil
+}
+
+// removeOrphanFile deletes the file at path if it is still not referenced.
+func (imgStore *DiskImageStore) removeOrphanFile(path string) bool {
+	imgStore.mutex.Lock()
+	defer imgStore.mutex.Unlock()
+
+	owned, referenced := imgStore.classify(path)
+	if !owned || referenced {
+		return false
+	}
+
+	err := os.Remove(path)
+	if err != nil {
+		log.Printf("cannot delete orphan file %s: %v", path, err)
+		return false
+	}
+
+	if filepath.Dir(path) != imgStore.imageFolder {
+		os.Remove(filepath.Dir(path))
+		os.Remove(filepath.Dir(filepath.Dir(path)))
+	}
+
+	return true
+}
+
+// classify reports whether the file at path is one the store writes, and whether the index
+// references it. The caller must hold the lock.
+func (imgStore *DiskImageStore) classify(path string) (owned, referenced bool) {
+	name := filepath.Base(path)
+	if strings.HasSuffix(name, ".tmp") {
+		return true, false
+	}
+
+	if filepath.Dir(path) == imgStore.imageFolder {
+		if strings.HasSuffix(name, IMAGE_METADATA_EXT) {
+			return true, imgStore.images[strings.TrimSuffix(name, IMAGE_METADATA_EXT)] != nil
+		}
+
+		// image files named after their id, left from before the blob layout, are only owned
+		// if the id is of a metadata file; other image files may not be ours
+		extension := strings.ToLower(filepath.Ext(name))
+		imageId, _, _ := strings.Cut(strings.TrimSuffix(name, filepath.Ext(name)), "_")
+		if !imgStore.metadataIds[imageId] {
+			return false, false
+		}
+
+		for _, extensions := range supportedImageTypes {
+			for _, other := range extensions {
+				if extension == other {
+					return true, imgStore.images[imageId] != nil
+				}
+			}
+		}
+
+		return false, false
+	}
+
+	checksum, variant, isVariant := strings.Cut(name, "_")
+	blob := imgStore.blobs[checksum]
+	if blob == nil {
+		return true, false
+	}
+	if !isVariant {
+		return true, true
+	}
+
+	for _, other := range blob.variants {
+		if other.Name == variant {
+			return true, true
+		}
+	}
+
+	return true, false
+}
+
+// imagesCreatedBefore returns the images saved before cutoff.
+func (imgStore *DiskImageStore) imagesCreatedBefore(cutoff time.Time) []*ImageInfo {
+	imgStore.mutex.RLock()
+	defer imgStore.mutex.RUnlock()
+
+	images := []*ImageInfo{}
+	for _, image := range imgStore.images {
+		if image.CreatedAt.Before(cutoff) {
+			images = append(images, image.Clone())
+		}
+	}
+
+	return images
+}
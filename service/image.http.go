@@ -0,0 +1,259 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"pc-book/pb"
+	"strings"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	IMAGE_FORM_FIELD    = "image"
+	CHECKSUM_FORM_FIELD = "checksum"
+	// MULTIPART_OVERHEAD is the room left in an upload request for the form fields and part headers.
+	MULTIPART_OVERHEAD = 64 << 10
+)
+
+// ImageHTTPHandler serves the image upload and download endpoints of the REST gateway,
+// which cannot carry the streaming UploadImage and DownloadImage RPCs from a browser.
+// The endpoints are authorized like the RPCs they stand for.
+type ImageHTTPHandler struct {
+	server *LaptopServer
+	auth   *AuthInterceptor
+	mux    *runtime.ServeMux
+}
+
+func NewImageHTTPHandler(server *LaptopServer, auth *AuthInterceptor) *ImageHTTPHandler {
+	return &ImageHTTPHandler{
+		server: server,
+		auth:   auth,
+	}
+}
+
+// Register adds the endpoints to mux:
+//
+//	POST /v1/laptop/{id}/images  uploads a multipart/form-data image to a laptop
+//	GET  /v1/images/{id}         downloads an image, or one of its variants with ?variant=,
+//	                             with an access token or from a URL returned by GetImageURL
+func (handler *ImageHTTPHandler) Register(mux *runtime.ServeMux) error {
+	handler.mux = mux
+
+	err := mux.HandlePath(http.MethodPost, "/v1/laptop/{id}/images", handler.Upload)
+	if err != nil {
+		return err
+	}
+
+	return mux.HandlePath(http.MethodGet, "/v1/images/{id}", handler.Download)
+}
+
+// Upload saves the file in the image field of a multipart form. The form may declare
+// the SHA-256 of the image in a checksum field. The image type is taken from the file name.
+func (handler *ImageHTTPHandler) Upload(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	laptopId := params["id"]
+
+	log.Printf("receive an http upload-image request for laptop %s", laptopId)
+
+	ctx, err := handler.auth.HTTPContext(r, laptopServiceMethod("UploadImage"))
+	if err != nil {
+		handler.writeError(r.Context(), w, r, err)
+		return
+	}
+
+	image, err := handler.upload(ctx, w, r, laptopId)
+	if err != nil {
+		handler.writeError(ctx, w, r, err)
+		return
+	}
+
+	log.Printf("saved image with id %s and size %d", image.Id, image.Size)
+
+	w.Header().Set("Location", "/v1/images/"+image.Id)
+	handler.writeMessage(w, r, http.StatusCreated, toImageInfoMessage(image))
+}
+
+func (handler *ImageHTTPHandler) upload(ctx context.Context, w http.ResponseWriter, r *http.Request, laptopId string) (*ImageInfo, error) {
+	laptop, err := handler.server.laptopStore.Find(laptopId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find laptop: %v", err)
+	}
+	if laptop == nil {
+		return nil, status.Errorf(codes.NotFound, "laptop %s does not exist", laptopId)
+	}
+
+	release, err := handler.server.acquireUpload(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	maxImageSize := handler.server.maxImageSize(ctx)
+	r.Body = http.MaxBytesReader(w, r.Body, maxImageSize+MULTIPART_OVERHEAD)
+
+	form, err := r.MultipartReader()
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "cannot read multipart form: %v", err)
+	}
+
+	var imageData *tempFile
+	defer func() {
+		if imageData != nil {
+			imageData.Close()
+		}
+	}()
+
+	imageType, checksum := "", ""
+	for {
+		part, err := form.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "cannot read multipart form: %v", err)
+		}
+
+		switch part.FormName() {
+		case CHECKSUM_FORM_FIELD:
+			value, err := io.ReadAll(io.LimitReader(part, 128))
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "cannot read checksum: %v", err)
+			}
+			checksum = strings.ToLower(strings.TrimSpace(string(value)))
+
+		case IMAGE_FORM_FIELD:
+			if imageData != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "form has more than one image")
+			}
+
+			imageType = strings.ToLower(filepath.Ext(part.FileName()))
+			imageData, err = createTempFile(handler.server.config.UploadFolder, "http.*.tmp")
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "cannot create image file: %v", err)
+			}
+
+			imageSize, err := io.Copy(imageData, io.LimitReader(part, maxImageSize+1))
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "cannot receive image data: %v", err)
+			}
+			if imageSize > maxImageSize {
+				return nil, status.Errorf(codes.InvalidArgument, "image data too large: limit is %d bytes", maxImageSize)
+			}
+		}
+
+		part.Close()
+	}
+
+	if imageData == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "form has no %s file", IMAGE_FORM_FIELD)
+	}
+
+	_, err = imageData.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot rewind image file: %v", err)
+	}
+
+	return handler.server.saveImage(ctx, laptopId, imageType, checksum, imageData.File)
+}
+
+// Download serves the bytes of an image with its content type, and an ETag made of its checksum.
+// Conditional and range requests are answered by http.ServeContent.
+func (handler *ImageHTTPHandler) Download(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	imageId, variant := params["id"], r.URL.Query().Get("variant")
+
+	log.Printf("receive an http download-image request for image %s, variant %q", imageId, variant)
+
+	ctx, err := handler.authorizeDownload(r, imageId, variant)
+	if err != nil {
+		handler.writeError(r.Context(), w, r, err)
+		return
+	}
+
+	image, file, err := handler.server.imageStore.Open(imageId, variant)
+	if err != nil {
+		handler.writeError(ctx, w, r, imageStoreError("cannot open image", err))
+		return
+	}
+	if file == nil {
+		handler.writeError(ctx, w, r, status.Errorf(codes.NotFound, "image %s does not exist", imageId))
+		return
+	}
+	defer file.Close()
+
+	checksum := image.Checksum
+	if variant != "" {
+		checksum = image.Variant(variant).Checksum
+	}
+
+	contentType := image.MimeType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(image.Type)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", fmt.Sprintf("%q", checksum))
+	http.ServeContent(w, r, "", image.CreatedAt, file)
+}
+
+// authorizeDownload accepts a URL signed by the ImageURLSigner of the server in place of an access token.
+// Without a signature, the caller must have one of the roles allowed to call DownloadImage, so images
+// are not public when that RPC has no roles.
+func (handler *ImageHTTPHandler) authorizeDownload(r *http.Request, imageId, variant string) (context.Context, error) {
+	query := r.URL.Query()
+	if !query.Has("signature") {
+		ctx, err := handler.auth.HTTPContext(r, laptopServiceMethod("DownloadImage"))
+		if err != nil {
+			return nil, err
+		}
+
+		_, ok := ClaimsFromContext(ctx)
+		if !ok {
+			return nil, status.Errorf(codes.Unauthenticated, "downloading an image requires a signed url or an access token")
+		}
+
+		return ctx, nil
+	}
+
+	signer := handler.server.config.ImageURLSigner
+	if signer == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "signed image urls are not enabled")
+	}
+
+	err := signer.Verify(imageId, variant, query.Get("expires"), query.Get("signature"))
+	if err != nil {
+		return nil, status.Errorf(codes.PermissionDenied, "%v", err)
+	}
+
+	return r.Context(), nil
+}
+
+func (handler *ImageHTTPHandler) writeError(ctx context.Context, w http.ResponseWriter, r *http.Request, err error) {
+	_, outbound := runtime.MarshalerForRequest(handler.mux, r)
+	runtime.HTTPError(ctx, handler.mux, outbound, w, r, err)
+}
+
+func (handler *ImageHTTPHandler) writeMessage(w http.ResponseWriter, r *http.Request, code int, message *pb.ImageInfo) {
+	_, outbound := runtime.MarshalerForRequest(handler.mux, r)
+
+	data, err := outbound.Marshal(message)
+	if err != nil {
+		handler.writeError(r.Context(), w, r, status.Errorf(codes.Internal, "cannot marshal response: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", outbound.ContentType(message))
+	w.WriteHeader(code)
+	w.Write(data)
+}
+
+// laptopServiceMethod returns the full name of a LaptopService RPC, as the auth interceptor sees it.
+func laptopServiceMethod(name string) string {
+	return "/" + pb.LaptopService_ServiceDesc.ServiceName + "/" + name
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_SCAN_TIMEOUT      = 30 * time.Second
+	DEFAULT_QUARANTINE_FOLDER = "tmp/quarantine"
+
+	// the exit codes of a scan command
+	SCAN_EXIT_ALLOW      = 0
+	SCAN_EXIT_REJECT     = 1
+	SCAN_EXIT_QUARANTINE = 2
+
+	// MAX_SCAN_REASON_LENGTH bounds the scan command output kept as the reason of its verdict.
+	MAX_SCAN_REASON_LENGTH = 256
+)
+
+type ScanVerdict int
+
+const (
+	// ScanAllow lets the image be saved.
+	ScanAllow ScanVerdict = iota
+	// ScanReject drops the image.
+	ScanReject
+	// ScanQuarantine drops the image but keeps a copy of it in the quarantine folder for review.
+	ScanQuarantine
+)
+
+func (verdict ScanVerdict) String() string {
+	switch verdict {
+	case ScanAllow:
+		return "allow"
+	case ScanReject:
+		return "reject"
+	case ScanQuarantine:
+		return "quarantine"
+	}
+
+	return fmt.Sprintf("ScanVerdict(%d)", int(verdict))
+}
+
+type ScanResult struct {
+	Verdict ScanVerdict
+	Reason  string
+}
+
+// Scanner inspects an uploaded image before it is saved to the image store.
+// An error means the image could not be scanned, and the upload fails.
+type Scanner interface {
+	Scan(ctx context.Context, path string) (*ScanResult, error)
+}
+
+// CommandScanner scans an image by running a local command with the image path as its last argument.
+// The command exits with SCAN_EXIT_ALLOW, SCAN_EXIT_REJECT or SCAN_EXIT_QUARANTINE, and may print
+// the reason of its verdict. Any other exit code fails the scan.
+type CommandScanner struct {
+	command string
+	args    []string
+	timeout time.Duration
+}
+
+func NewCommandScanner(command string, args []string, timeout time.Duration) *CommandScanner {
+	return &CommandScanner{
+		command: command,
+		args:    args,
+		timeout: timeout,
+	}
+}
+
+// Scan implements Scanner.
+func (scanner *CommandScanner) Scan(ctx context.Context, path string) (*ScanResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, scanner.timeout)
+	defer cancel()
+
+	output := bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, scanner.command, append(append([]string{}, scanner.args...), path)...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := cmd.Run()
+
+	exitCode := 0
+	exitErr := &exec.ExitError{}
+	if errors.As(err, &exitErr) && ctx.Err() == nil {
+		exitCode = exitErr.ExitCode()
+	} else if err != nil {
+		return nil, fmt.Errorf("cannot run scan command: %w", err)
+	}
+
+	reason := strings.TrimSpace(output.String())
+	if len(reason) > MAX_SCAN_REASON_LENGTH {
+		reason = reason[:MAX_SCAN_REASON_LENGTH]
+	}
+
+	switch exitCode {
+	case SCAN_EXIT_ALLOW:
+		return &ScanResult{Verdict: ScanAllow, Reason: reason}, nil
+	case SCAN_EXIT_REJECT:
+		return &ScanResult{Verdict: ScanReject, Reason: reason}, nil
+	case SCAN_EXIT_QUARANTINE:
+		return &ScanResult{Verdict: ScanQuarantine, Reason: reason}, nil
+	}
+
+	return nil, fmt.Errorf("scan command exited with code %d: %s", exitCode, reason)
+}
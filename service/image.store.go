@@ -1,62 +1,361 @@
 package service
 
 import (
-	"bytes"
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
+	"io"
+	"log"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+// IMAGE_METADATA_EXT is the extension of the sidecar file that holds the metadata of each stored image.
+const IMAGE_METADATA_EXT = ".meta.json"
+
 type ImageStore interface {
-	Save(laptopId, imageType string, imageData bytes.Buffer) (string, error)
+	Save(info *ImageInfo, imageData io.Reader) (string, error)
+	SaveFile(info *ImageInfo, path string) (string, error)
+	Find(imageId string) (*ImageInfo, error)
+	Open(imageId, variant string) (*ImageInfo, io.ReadSeekCloser, error)
+	List(laptopId string) ([]*ImageInfo, error)
+	Primary(laptopId string) (*ImageInfo, error)
+	Delete(imageId string) error
+	DeleteLaptop(laptopId string) (int, error)
+	SetPrimary(laptopId, imageId string) error
+	Reorder(laptopId string, imageIds []string) ([]*ImageInfo, error)
 }
 
 type ImageInfo struct {
-	LaptopId, Type, Path string
+	Id       string `json:"id"`
+	LaptopId string `json:"laptop_id"`
+	Type     string `json:"type"`
+	MimeType string `json:"mime_type"`
+	Width    uint32 `json:"width"`
+	Height   uint32 `json:"height"`
+	Size     uint64 `json:"size"`
+	Checksum string `json:"checksum"`
+	Position uint32 `json:"position"`
+	Primary  bool   `json:"primary"`
+	Path     string `json:"-"`
+
+	// MetadataStripped tells that the EXIF, XMP and other metadata were removed from the image when it was uploaded.
+	MetadataStripped bool `json:"metadata_stripped"`
+
+	CreatedAt time.Time `json:"created_at"`
+
+	Variants []*ImageVariant `json:"variants,omitempty"`
+}
+
+func (image *ImageInfo) Clone() *ImageInfo {
+	other := *image
+
+	other.Variants = make([]*ImageVariant, len(image.Variants))
+	for i, variant := range image.Variants {
+		otherVariant := *variant
+		other.Variants[i] = &otherVariant
+	}
+
+	return &other
+}
+
+// Variant returns the variant with the given name, or nil if the image has none.
+func (image *ImageInfo) Variant(name string) *ImageVariant {
+	for _, variant := range image.Variants {
+		if variant.Name == name {
+			return variant
+		}
+	}
+
+	return nil
 }
 
 type DiskImageStore struct {
-	mutex       sync.RWMutex
-	imageFolder string
-	images      map[string]*ImageInfo
+	mutex          sync.RWMutex
+	imageFolder    string
+	thumbnailSizes []int
+	images         map[string]*ImageInfo
+	galleries      map[string][]*ImageInfo // laptop id -> images in gallery order
+	blobs          map[string]*imageBlob
+	unattributed   []string
+	metadataIds    map[string]bool // ids of the metadata files found at startup
+}
+
+// NewDiskImageStore creates an image store in imageFolder and rebuilds its index
+// from the metadata files already in the folder.
+func NewDiskImageStore(imageFolder string) (*DiskImageStore, error) {
+	return NewDiskImageStoreWithThumbnails(imageFolder, nil)
 }
 
-func NewDiskImageStore(imageFolder string) ImageStore {
-	return &DiskImageStore{
-		imageFolder: imageFolder,
-		images:      make(map[string]*ImageInfo),
+// NewDiskImageStoreWithThumbnails creates an image store that also writes a thumbnail
+// of every saved image for each of thumbnailSizes, the length of its longest edge in pixels.
+func NewDiskImageStoreWithThumbnails(imageFolder string, thumbnailSizes []int) (*DiskImageStore, error) {
+	store := &DiskImageStore{
+		imageFolder:    imageFolder,
+		thumbnailSizes: thumbnailSizes,
+		images:         make(map[string]*ImageInfo),
+		galleries:      make(map[string][]*ImageInfo),
+		blobs:          make(map[string]*imageBlob),
+		metadataIds:    make(map[string]bool),
+	}
+
+	err := store.load()
+	if err != nil {
+		return nil, err
 	}
+
+	return store, nil
 }
 
 // Save implements ImageStore.
-func (imgStore *DiskImageStore) Save(laptopId string, imageType string, imageData bytes.Buffer) (string, error) {
-	imageId, err := uuid.NewRandom()
+// The laptop id, type and content fields are taken from info, the rest is filled in by the store.
+// imageData is streamed to disk, and if info has a checksum, it must match the SHA-256 of imageData.
+// Contents that are already stored are shared instead of written again.
+func (imgStore *DiskImageStore) Save(info *ImageInfo, imageData io.Reader) (string, error) {
+	tempPath, checksum, size, err := imgStore.receiveBlob(imageData)
 	if err != nil {
-		return "", fmt.Errorf("cannot generate image id: %w", err)
+		return "", err
 	}
+	defer os.Remove(tempPath)
 
-	imagePath := fmt.Sprintf("%s/%s%s", imgStore.imageFolder, imageId, imageType)
+	return imgStore.saveBlob(info, tempPath, checksum, size)
+}
 
-	f, err := os.Create(imagePath)
+// SaveFile implements ImageStore.
+// It works like Save, but the file at path is hashed in place and linked into the store
+// instead of copied, when both are on the same file system. The caller still owns path.
+func (imgStore *DiskImageStore) SaveFile(info *ImageInfo, path string) (string, error) {
+	checksum, size, err := hashFile(path)
 	if err != nil {
-		return "", fmt.Errorf("cannot create image file: %w", err)
+		return "", err
 	}
 
-	_, err = imageData.WriteTo(f)
+	return imgStore.saveBlob(info, path, checksum, size)
+}
+
+// saveBlob saves an image whose content is the file at tempPath.
+func (imgStore *DiskImageStore) saveBlob(info *ImageInfo, tempPath, checksum string, size uint64) (string, error) {
+	imageId, err := uuid.NewRandom()
 	if err != nil {
-		return "", fmt.Errorf("cannot write image to file: %w", err)
+		return "", fmt.Errorf("cannot generate image id: %w", err)
+	}
+
+	if info.Checksum != "" && !strings.EqualFold(info.Checksum, checksum) {
+		return "", fmt.Errorf("%w: got %s, declared %s", ErrImageChecksumMismatch, checksum, info.Checksum)
+	}
+
+	image := &ImageInfo{
+		Id:       imageId.String(),
+		LaptopId: info.LaptopId,
+		Type:     info.Type,
+		MimeType: info.MimeType,
+		Width:    info.Width,
+		Height:   info.Height,
+		Size:     size,
+		Checksum: checksum,
+		Path:     imgStore.blobPath(checksum),
+
+		MetadataStripped: info.MetadataStripped,
+		CreatedAt:        time.Now(),
+	}
+
+	imgStore.mutex.RLock()
+	blob := imgStore.blobs[checksum]
+	imgStore.mutex.RUnlock()
+
+	written := false
+	if blob == nil {
+		image.Variants, err = imgStore.writeBlob(checksum, tempPath)
+		if err != nil {
+			return "", err
+		}
+		written = true
 	}
 
 	imgStore.mutex.Lock()
 	defer imgStore.mutex.Unlock()
 
-	imgStore.images[imageId.String()] = &ImageInfo{
-		LaptopId: laptopId,
-		Type:     imageType,
-		Path:     imagePath,
+	// the blob may have been released by a delete since it was looked up
+	blob = imgStore.blobs[checksum]
+	if blob != nil {
+		image.Variants = blob.variants
+	} else if _, err := os.Stat(image.Path); !written || err != nil {
+		image.Variants, err = imgStore.writeBlob(checksum, tempPath)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	gallery := imgStore.gallery(image.LaptopId)
+	image.Position = uint32(len(gallery))
+	image.Primary = primaryImage(gallery) == nil
+
+	imgStore.retain(image)
+
+	err = imgStore.writeMetadata(image)
+	if err != nil {
+		imgStore.release(image)
+		return "", err
+	}
+
+	imgStore.images[image.Id] = image
+	imgStore.galleries[image.LaptopId] = append(gallery, image)
+
+	return image.Id, nil
+}
+
+// Find implements ImageStore.
+func (imgStore *DiskImageStore) Find(imageId string) (*ImageInfo, error) {
+	imgStore.mutex.RLock()
+	defer imgStore.mutex.RUnlock()
+
+	image := imgStore.images[imageId]
+	if image == nil {
+		return nil, nil
 	}
 
-	return imageId.String(), nil
+	return image.Clone(), nil
+}
+
+// Open implements ImageStore.
+// An empty variant opens the original image. It returns a nil reader when the image does not exist.
+func (imgStore *DiskImageStore) Open(imageId, variant string) (*ImageInfo, io.ReadSeekCloser, error) {
+	image, err := imgStore.Find(imageId)
+	if err != nil || image == nil {
+		return nil, nil, err
+	}
+
+	path := image.Path
+	if variant != "" {
+		other := image.Variant(variant)
+		if other == nil {
+			return nil, nil, fmt.Errorf("%w: %s of image %s", ErrImageVariantNotFound, variant, imageId)
+		}
+
+		path = other.Path
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot open image file: %w", err)
+	}
+
+	return image, file, nil
+}
+
+// Unattributed returns the files found in the image folder at startup that do not belong to any laptop.
+func (imgStore *DiskImageStore) Unattributed() []string {
+	imgStore.mutex.RLock()
+	defer imgStore.mutex.RUnlock()
+
+	return append([]string(nil), imgStore.unattributed...)
+}
+
+func (imgStore *DiskImageStore) metadataPath(imageId string) string {
+	return filepath.Join(imgStore.imageFolder, imageId+IMAGE_METADATA_EXT)
+}
+
+func (imgStore *DiskImageStore) writeMetadata(image *ImageInfo) error {
+	data, err := json.Marshal(image)
+	if err != nil {
+		return fmt.Errorf("cannot marshal image metadata: %w", err)
+	}
+
+	err = writeFileAtomic(imgStore.metadataPath(image.Id), data)
+	if err != nil {
+		return fmt.Errorf("cannot write image metadata: %w", err)
+	}
+
+	return nil
+}
+
+func (imgStore *DiskImageStore) load() error {
+	err := os.MkdirAll(imgStore.imageFolder, 0755)
+	if err != nil {
+		return fmt.Errorf("cannot create image folder: %w", err)
+	}
+
+	entries, err := os.ReadDir(imgStore.imageFolder)
+	if err != nil {
+		return fmt.Errorf("cannot read image folder: %w", err)
+	}
+
+	attributed := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), IMAGE_METADATA_EXT) {
+			continue
+		}
+
+		metadataFile := filepath.Join(imgStore.imageFolder, entry.Name())
+		attributed[entry.Name()] = true
+
+		metadataId := strings.TrimSuffix(entry.Name(), IMAGE_METADATA_EXT)
+		if _, err := uuid.Parse(metadataId); err == nil {
+			imgStore.metadataIds[metadataId] = true
+		}
+
+		data, err := os.ReadFile(metadataFile)
+		if err != nil {
+			return fmt.Errorf("cannot read image metadata: %w", err)
+		}
+
+		image := &ImageInfo{}
+		err = json.Unmarshal(data, image)
+		if err != nil {
+			log.Printf("cannot parse image metadata %s: %v", metadataFile, err)
+			continue
+		}
+
+		if len(image.Checksum) != sha256.Size*2 {
+			log.Printf("image metadata %s has an invalid checksum", metadataFile)
+			continue
+		}
+
+		image.Path = imgStore.blobPath(image.Checksum)
+		for _, name := range imgStore.migrateLegacyFiles(image) {
+			attributed[name] = true
+		}
+
+		_, err = os.Stat(image.Path)
+		if err != nil {
+			log.Printf("image file of %s is missing: %v", metadataFile, err)
+			continue
+		}
+
+		for _, variant := range image.Variants {
+			variant.Path = imgStore.blobVariantPath(image.Checksum, variant.Name)
+		}
+
+		imgStore.images[image.Id] = image
+		imgStore.galleries[image.LaptopId] = append(imgStore.galleries[image.LaptopId], image)
+		imgStore.retain(image)
+	}
+
+	for _, gallery := range imgStore.galleries {
+		sortGallery(gallery)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || attributed[entry.Name()] {
+			continue
+		}
+
+		path := filepath.Join(imgStore.imageFolder, entry.Name())
+		log.Printf("cannot attribute image file %s to a laptop", path)
+		imgStore.unattributed = append(imgStore.unattributed, path)
+	}
+
+	for _, path := range imgStore.unattributedBlobs() {
+		log.Printf("cannot attribute image file %s to a laptop", path)
+		imgStore.unattributed = append(imgStore.unattributed, path)
+	}
+
+	log.Printf("loaded %d images from %s", len(imgStore.images), imgStore.imageFolder)
+
+	return nil
 }
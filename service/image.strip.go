@@ -0,0 +1,271 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrMalformedImage = errors.New("malformed image")
+
+const (
+	jpegMarkerSOI  = 0xd8
+	jpegMarkerEOI  = 0xd9
+	jpegMarkerSOS  = 0xda
+	jpegMarkerAPP1 = 0xe1 // EXIF and XMP
+	jpegMarkerAPPD = 0xed // IPTC
+	jpegMarkerCOM  = 0xfe
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+var exifHeader = []byte("Exif\x00\x00")
+
+const (
+	exifTagOrientation = 0x0112
+	tiffTypeShort      = 3
+)
+
+// pngRenderingChunks are the ancillary PNG chunks that change how the image looks, so they are kept.
+var pngRenderingChunks = map[string]bool{
+	"tRNS": true,
+	"gAMA": true,
+	"cHRM": true,
+	"sRGB": true,
+	"iCCP": true,
+	"sBIT": true,
+}
+
+// StripImageMetadata copies an image of the given MIME type from src to dst without its metadata,
+// and returns the number of metadata segments it removed. The image data itself is copied as is.
+// From JPEGs it removes the EXIF, XMP and IPTC segments and the comments, and from PNGs
+// the ancillary chunks that do not affect rendering, such as text, time and EXIF chunks.
+// The EXIF orientation of a JPEG changes how it is displayed, so it is kept in an EXIF segment
+// of its own, which is not counted as removed.
+func StripImageMetadata(mimeType string, dst io.Writer, src io.Reader) (int, error) {
+	switch mimeType {
+	case "image/jpeg":
+		return stripJPEGMetadata(dst, bufio.NewReader(src))
+	case "image/png":
+		return stripPNGMetadata(dst, bufio.NewReader(src))
+	}
+
+	return 0, fmt.Errorf("%w: %s", ErrUnsupportedImageType, mimeType)
+}
+
+func stripJPEGMetadata(dst io.Writer, src *bufio.Reader) (int, error) {
+	header := make([]byte, 2)
+	_, err := io.ReadFull(src, header)
+	if err != nil || header[0] != 0xff || header[1] != jpegMarkerSOI {
+		return 0, fmt.Errorf("%w: missing jpeg start of image", ErrMalformedImage)
+	}
+
+	_, err = dst.Write(header)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for {
+		marker, err := readJPEGMarker(src)
+		if err != nil {
+			return 0, err
+		}
+
+		if marker == jpegMarkerEOI {
+			_, err = dst.Write([]byte{0xff, marker})
+			return removed, err
+		}
+
+		length := make([]byte, 2)
+		_, err = io.ReadFull(src, length)
+		if err != nil || binary.BigEndian.Uint16(length) < 2 {
+			return 0, fmt.Errorf("%w: truncated jpeg segment", ErrMalformedImage)
+		}
+
+		segmentLength := int64(binary.BigEndian.Uint16(length)) - 2
+
+		if marker == jpegMarkerAPP1 {
+			segment := bytes.Buffer{}
+			err = copySegment(&segment, src, segmentLength)
+			if err != nil {
+				return 0, err
+			}
+
+			orientation := uint16(0)
+			if bytes.HasPrefix(segment.Bytes(), exifHeader) {
+				orientation = exifOrientation(segment.Bytes()[len(exifHeader):])
+			}
+			if orientation < 2 {
+				removed++
+				continue
+			}
+
+			_, err = dst.Write(orientationSegment(orientation))
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		if marker == jpegMarkerAPPD || marker == jpegMarkerCOM {
+			err = copySegment(io.Discard, src, segmentLength)
+			if err != nil {
+				return 0, err
+			}
+
+			removed++
+			continue
+		}
+
+		_, err = dst.Write([]byte{0xff, marker, length[0], length[1]})
+		if err == nil {
+			err = copySegment(dst, src, segmentLength)
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		// the metadata segments come before the first scan, so the rest is copied as is
+		if marker == jpegMarkerSOS {
+			_, err = io.Copy(dst, src)
+			return removed, err
+		}
+	}
+}
+
+// exifOrientation returns the orientation tag in the first IFD of the TIFF structure of an EXIF segment,
+// or 0 if it has none.
+func exifOrientation(tiff []byte) uint16 {
+	if len(tiff) < 8 {
+		return 0
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	offset := uint64(order.Uint32(tiff[4:]))
+	if offset+2 > uint64(len(tiff)) {
+		return 0
+	}
+
+	count := int(order.Uint16(tiff[offset:]))
+	entries := tiff[offset+2:]
+	for i := 0; i < count && (i+1)*12 <= len(entries); i++ {
+		entry := entries[i*12:]
+		if order.Uint16(entry) == exifTagOrientation && order.Uint16(entry[2:]) == tiffTypeShort {
+			// a single short is stored in the first bytes of the value field
+			return order.Uint16(entry[8:])
+		}
+	}
+
+	return 0
+}
+
+// orientationSegment returns a JPEG APP1 segment with an EXIF structure that holds only the orientation.
+func orientationSegment(orientation uint16) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	tiff = binary.BigEndian.AppendUint16(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, exifTagOrientation)
+	tiff = binary.BigEndian.AppendUint16(tiff, tiffTypeShort)
+	tiff = binary.BigEndian.AppendUint32(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0)
+
+	// no next IFD
+	tiff = binary.BigEndian.AppendUint32(tiff, 0)
+
+	segment := []byte{0xff, jpegMarkerAPP1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(2+len(exifHeader)+len(tiff)))
+	segment = append(segment, exifHeader...)
+
+	return append(segment, tiff...)
+}
+
+// readJPEGMarker reads the code of the next marker, skipping its fill bytes.
+func readJPEGMarker(src *bufio.Reader) (byte, error) {
+	prefix, err := src.ReadByte()
+	if err != nil || prefix != 0xff {
+		return 0, fmt.Errorf("%w: missing jpeg marker", ErrMalformedImage)
+	}
+
+	for {
+		marker, err := src.ReadByte()
+		if err != nil {
+			return 0, fmt.Errorf("%w: missing jpeg marker", ErrMalformedImage)
+		}
+		if marker != 0xff {
+			return marker, nil
+		}
+	}
+}
+
+func stripPNGMetadata(dst io.Writer, src *bufio.Reader) (int, error) {
+	signature := make([]byte, len(pngSignature))
+	_, err := io.ReadFull(src, signature)
+	if err != nil || !bytes.Equal(signature, pngSignature) {
+		return 0, fmt.Errorf("%w: missing png signature", ErrMalformedImage)
+	}
+
+	_, err = dst.Write(signature)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for {
+		header := make([]byte, 8)
+		_, err := io.ReadFull(src, header)
+		if err != nil {
+			return 0, fmt.Errorf("%w: truncated png chunk", ErrMalformedImage)
+		}
+
+		// the chunk data is followed by its crc
+		chunkLength := int64(binary.BigEndian.Uint32(header[:4])) + 4
+		chunkType := string(header[4:])
+
+		// the case of the first letter tells ancillary chunks from critical ones
+		ancillary := chunkType[0]&0x20 != 0
+		if ancillary && !pngRenderingChunks[chunkType] {
+			err = copySegment(io.Discard, src, chunkLength)
+			if err != nil {
+				return 0, err
+			}
+
+			removed++
+			continue
+		}
+
+		_, err = dst.Write(header)
+		if err == nil {
+			err = copySegment(dst, src, chunkLength)
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if chunkType == "IEND" {
+			return removed, nil
+		}
+	}
+}
+
+// copySegment copies the n bytes of a segment or chunk, which the image must hold.
+func copySegment(dst io.Writer, src io.Reader, n int64) error {
+	_, err := io.CopyN(dst, src, n)
+	if err == io.EOF {
+		return fmt.Errorf("%w: truncated segment", ErrMalformedImage)
+	}
+
+	return err
+}
@@ -0,0 +1,186 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"os"
+)
+
+const THUMBNAIL_JPEG_QUALITY = 85
+
+var ErrImageVariantNotFound = errors.New("image variant not found")
+
+// ImageVariant is a resized copy of an image stored next to the original.
+type ImageVariant struct {
+	Name     string `json:"name"`
+	Width    uint32 `json:"width"`
+	Height   uint32 `json:"height"`
+	Size     uint64 `json:"size"`
+	Checksum string `json:"checksum"`
+	Path     string `json:"-"`
+}
+
+func thumbnailVariant(size int) string {
+	return fmt.Sprintf("thumb_%d", size)
+}
+
+// writeThumbnails decodes the blob of checksum and writes one thumbnail per configured size
+// next to it, returning the variants it wrote.
+func (imgStore *DiskImageStore) writeThumbnails(checksum string) ([]*ImageVariant, error) {
+	if len(imgStore.thumbnailSizes) == 0 {
+		return nil, nil
+	}
+
+	file, err := os.Open(imgStore.blobPath(checksum))
+	if err != nil {
+		return nil, fmt.Errorf("cannot open image file: %w", err)
+	}
+	defer file.Close()
+
+	src, format, err := image.Decode(bufio.NewReader(file))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode image: %w", err)
+	}
+
+	variants := []*ImageVariant{}
+	for _, size := range imgStore.thumbnailSizes {
+		thumbnail := makeThumbnail(src, size)
+
+		data := bytes.Buffer{}
+		err := encodeImage(&data, thumbnail, format)
+		if err != nil {
+			removeVariants(variants)
+			return nil, err
+		}
+
+		sum := sha256.Sum256(data.Bytes())
+		variant := &ImageVariant{
+			Name:     thumbnailVariant(size),
+			Width:    uint32(thumbnail.Bounds().Dx()),
+			Height:   uint32(thumbnail.Bounds().Dy()),
+			Size:     uint64(data.Len()),
+			Checksum: hex.EncodeToString(sum[:]),
+		}
+		variant.Path = imgStore.blobVariantPath(checksum, variant.Name)
+
+		err = writeFileAtomic(variant.Path, data.Bytes())
+		if err != nil {
+			removeVariants(variants)
+			return nil, fmt.Errorf("cannot write thumbnail to file: %w", err)
+		}
+
+		variants = append(variants, variant)
+	}
+
+	return variants, nil
+}
+
+func removeVariants(variants []*ImageVariant) {
+	for _, variant := range variants {
+		os.Remove(variant.Path)
+	}
+}
+
+func encodeImage(data *bytes.Buffer, img image.Image, format string) error {
+	var err error
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(data, img, &jpeg.Options{Quality: THUMBNAIL_JPEG_QUALITY})
+	case "png":
+		err = png.Encode(data, img)
+	default:
+		return fmt.Errorf("%w: cannot encode %s", ErrUnsupportedImageType, format)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot encode thumbnail: %w", err)
+	}
+
+	return nil
+}
+
+// makeThumbnail scales src so that its longest edge is size pixels.
+// Images that are already small enough keep their dimensions.
+func makeThumbnail(src image.Image, size int) image.Image {
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+
+	longest := width
+	if height > longest {
+		longest = height
+	}
+
+	if longest > size {
+		width = width * size / longest
+		height = height * size / longest
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+
+	return resizeImage(src, width, height)
+}
+
+// resizeImage scales src to width x height, averaging the source pixels covered by each target pixel.
+// The source is converted one row at a time, so memory use does not grow with the source size.
+func resizeImage(src image.Image, width, height int) *image.RGBA {
+	bounds := src.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+
+	row := image.NewRGBA(image.Rect(0, 0, srcWidth, 1))
+	sums := make([]uint64, width*4)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0, y1 := y*srcHeight/height, (y+1)*srcHeight/height
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+
+		for i := range sums {
+			sums[i] = 0
+		}
+
+		for sy := y0; sy < y1; sy++ {
+			draw.Draw(row, row.Bounds(), src, image.Pt(bounds.Min.X, bounds.Min.Y+sy), draw.Src)
+
+			for x := 0; x < width; x++ {
+				x0, x1 := x*srcWidth/width, (x+1)*srcWidth/width
+				if x1 == x0 {
+					x1 = x0 + 1
+				}
+
+				for offset := x0 * 4; offset < x1*4; offset += 4 {
+					sums[x*4] += uint64(row.Pix[offset])
+					sums[x*4+1] += uint64(row.Pix[offset+1])
+					sums[x*4+2] += uint64(row.Pix[offset+2])
+					sums[x*4+3] += uint64(row.Pix[offset+3])
+				}
+			}
+		}
+
+		for x := 0; x < width; x++ {
+			x0, x1 := x*srcWidth/width, (x+1)*srcWidth/width
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+			n := uint64((x1 - x0) * (y1 - y0))
+
+			offset := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[offset+c] = uint8(sums[x*4+c] / n)
+			}
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const DEFAULT_IMAGE_URL_TTL = 15 * time.Minute
+
+var (
+	ErrImageURLInvalid = errors.New("invalid image url signature")
+	ErrImageURLExpired = errors.New("image url has expired")
+)
+
+// ImageURLSigner signs the URLs of the REST image endpoint with HMAC-SHA256, so that
+// a link to an image can be handed out without an access token until it expires.
+type ImageURLSigner struct {
+	baseURL string
+	secret  []byte
+	ttl     time.Duration
+}
+
+// NewImageURLSigner creates a signer for URLs that last ttl. The signed URLs are
+// prefixed with baseURL, which may be empty to return paths.
+func NewImageURLSigner(baseURL string, secret []byte, ttl time.Duration) *ImageURLSigner {
+	return &ImageURLSigner{
+		baseURL: baseURL,
+		secret:  secret,
+		ttl:     ttl,
+	}
+}
+
+// Sign returns the signed URL of the variant of an image, empty for the original, and its expiry.
+func (signer *ImageURLSigner) Sign(imageId, variant string) (string, time.Time) {
+	expiresAt := time.Now().Add(signer.ttl).Truncate(time.Second)
+	expires := strconv.FormatInt(expiresAt.Unix(), 10)
+
+	query := url.Values{}
+	if variant != "" {
+		query.Set("variant", variant)
+	}
+	query.Set("expires", expires)
+	query.Set("signature", signer.signature(imageId, variant, expires))
+
+	return fmt.Sprintf("%s/v1/images/%s?%s", signer.baseURL, url.PathEscape(imageId), query.Encode()), expiresAt
+}
+
+// Verify checks the expires and signature query values of a signed URL.
+func (signer *ImageURLSigner) Verify(imageId, variant, expires, signature string) error {
+	expected, err := hex.DecodeString(signer.signature(imageId, variant, expires))
+	if err != nil {
+		return err
+	}
+
+	actual, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(expected, actual) {
+		return ErrImageURLInvalid
+	}
+
+	seconds, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrImageURLInvalid
+	}
+	if time.Now().After(time.Unix(seconds, 0)) {
+		return ErrImageURLExpired
+	}
+
+	return nil
+}
+
+func (signer *ImageURLSigner) signature(imageId, variant, expires string) string {
+	mac := hmac.New(sha256.New, signer.secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s", imageId, variant, expires)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
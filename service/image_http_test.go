@@ -0,0 +1,187 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"pc-book/pb"
+	"pc-book/sample"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+func TestImageHTTPHandler(t *testing.T) {
+	t.Parallel()
+
+	laptopServer, _ := startLaptopServer(t)
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopServer.laptopStore.Save(laptop))
+
+	jwtManager := NewJwtManager("secret", time.Minute)
+	auth := NewAuthInterceptor(jwtManager, map[string][]string{
+		laptopServiceMethod("UploadImage"):   {"admin"},
+		laptopServiceMethod("DownloadImage"): {"admin"},
+	})
+
+	mux := runtime.NewServeMux()
+	require.NoError(t, NewImageHTTPHandler(laptopServer, auth).Register(mux))
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	imageData, err := os.ReadFile("../img/laptop.jpg")
+	require.NoError(t, err)
+
+	res := postImage(t, server.URL+"/v1/laptop/"+laptop.Id+"/images", "", imageData)
+	require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	user, err := NewUser("admin", "secret", "admin")
+	require.NoError(t, err)
+	accessToken, err := jwtManager.Generate(user)
+	require.NoError(t, err)
+
+	res = postImage(t, server.URL+"/v1/laptop/"+laptop.Id+"/images", accessToken, imageData)
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	info := &pb.ImageInfo{}
+	require.NoError(t, protojson.Unmarshal(body, info))
+	require.Equal(t, laptop.Id, info.GetLaptopId())
+	require.Equal(t, "/v1/images/"+info.GetImageId(), res.Header.Get("Location"))
+
+	checksum := sha256.Sum256(imageData)
+	etag := `"` + hex.EncodeToString(checksum[:]) + `"`
+
+	res, err = http.Get(server.URL + "/v1/images/" + info.GetImageId())
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/v1/images/"+info.GetImageId(), nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	body, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "image/jpeg", res.Header.Get("Content-Type"))
+	require.Equal(t, etag, res.Header.Get("ETag"))
+	require.Equal(t, imageData, body)
+
+	req.Header.Set("Range", "bytes=10-19")
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	body, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusPartialContent, res.StatusCode)
+	require.Equal(t, imageData[10:20], body)
+
+	req.Header.Del("Range")
+	req.Header.Set("If-None-Match", etag)
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotModified, res.StatusCode)
+
+	req, err = http.NewRequest(http.MethodGet, server.URL+"/v1/images/unknown", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func postImage(t *testing.T, url, accessToken string, imageData []byte) *http.Response {
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+
+	part, err := form.CreateFormFile(IMAGE_FORM_FIELD, "laptop.jpg")
+	require.NoError(t, err)
+	_, err = part.Write(imageData)
+	require.NoError(t, err)
+	require.NoError(t, form.Close())
+
+	req, err := http.NewRequest(http.MethodPost, url, body)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { res.Body.Close() })
+
+	return res
+}
+
+func TestImageHTTPHandlerSignedURL(t *testing.T) {
+	t.Parallel()
+
+	laptopServer, serverAddr := startLaptopServer(t)
+	laptopClient := newLaptopCient(t, serverAddr)
+
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopServer.laptopStore.Save(laptop))
+
+	imageData, err := os.ReadFile("../img/laptop.jpg")
+	require.NoError(t, err)
+	imageId := uploadImage(t, laptopClient, laptop.Id, ".jpg", imageData)
+
+	// without a shared secret, urls are not signed
+	_, err = laptopClient.GetImageURL(context.Background(), &pb.GetImageURLRequest{ImageId: imageId})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	laptopServer.config.ImageURLSigner = NewImageURLSigner("", []byte("secret"), time.Minute)
+
+	auth := NewAuthInterceptor(NewJwtManager("secret", time.Minute), map[string][]string{
+		laptopServiceMethod("DownloadImage"): {"admin"},
+	})
+
+	mux := runtime.NewServeMux()
+	require.NoError(t, NewImageHTTPHandler(laptopServer, auth).Register(mux))
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/v1/images/" + imageId)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	signed, err := laptopClient.GetImageURL(context.Background(), &pb.GetImageURLRequest{ImageId: imageId})
+	require.NoError(t, err)
+	require.True(t, signed.GetExpiresAt().AsTime().After(time.Now()))
+
+	res, err = http.Get(server.URL + signed.GetUrl())
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, imageData, body)
+
+	res, err = http.Get(server.URL + strings.Replace(signed.GetUrl(), "expires=", "expires=1", 1))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	expired := NewImageURLSigner("", []byte("secret"), -time.Minute)
+	url, _ := expired.Sign(imageId, "")
+	res, err = http.Get(server.URL + url)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	_, err = laptopClient.GetImageURL(context.Background(), &pb.GetImageURLRequest{ImageId: "unknown"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
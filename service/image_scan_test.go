@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"pc-book/pb"
+	"pc-book/sample"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// stubScanScript rejects the images containing EICAR, quarantines the ones containing SUSPECT,
+// fails on the ones containing BROKEN and allows the others.
+const stubScanScript = `#!/bin/sh
+if grep -q EICAR "$1"; then echo "malware found"; exit 1; fi
+if grep -q SUSPECT "$1"; then echo "needs review"; exit 2; fi
+if grep -q BROKEN "$1"; then exit 3; fi
+echo clean
+`
+
+func newStubScanner(t *testing.T) *CommandScanner {
+	script := filepath.Join(t.TempDir(), "scan.sh")
+	require.NoError(t, os.WriteFile(script, []byte(stubScanScript), 0755))
+
+	return NewCommandScanner(script, nil, 10*time.Second)
+}
+
+func TestCommandScanner(t *testing.T) {
+	t.Parallel()
+
+	scanner := newStubScanner(t)
+	folder := t.TempDir()
+
+	testCases := []struct {
+		name    string
+		content string
+		verdict ScanVerdict
+		reason  string
+	}{
+		{"allow", "image", ScanAllow, "clean"},
+		{"reject", "image EICAR", ScanReject, "malware found"},
+		{"quarantine", "image SUSPECT", ScanQuarantine, "needs review"},
+	}
+
+	for _, tc := range testCases {
+		path := filepath.Join(folder, tc.name)
+		require.NoError(t, os.WriteFile(path, []byte(tc.content), 0644))
+
+		result, err := scanner.Scan(context.Background(), path)
+		require.NoError(t, err)
+		require.Equal(t, &ScanResult{Verdict: tc.verdict, Reason: tc.reason}, result)
+	}
+
+	path := filepath.Join(folder, "broken")
+	require.NoError(t, os.WriteFile(path, []byte("BROKEN"), 0644))
+
+	_, err := scanner.Scan(context.Background(), path)
+	require.Error(t, err)
+
+	_, err = NewCommandScanner(filepath.Join(folder, "missing"), nil, time.Second).Scan(context.Background(), path)
+	require.Error(t, err)
+}
+
+func TestLaptopClientUploadImageScanned(t *testing.T) {
+	t.Parallel()
+
+	laptopServer, serverAddr := startLaptopServer(t)
+	laptopServer.config.Scanner = newStubScanner(t)
+	laptopServer.config.QuarantineFolder = t.TempDir()
+	laptopClient := newLaptopCient(t, serverAddr)
+
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopServer.laptopStore.Save(laptop))
+
+	jpegData, err := os.ReadFile("../img/laptop.jpg")
+	require.NoError(t, err)
+
+	res, err := sendImage(t, laptopClient, &pb.ImageInfo{LaptopId: laptop.Id, ImageType: ".jpg"}, jpegData)
+	require.NoError(t, err)
+	require.NotEmpty(t, res.GetId())
+
+	// data after the end of a jpeg is kept by the stripper, so the scanner sees it
+	_, err = sendImage(t, laptopClient, &pb.ImageInfo{LaptopId: laptop.Id, ImageType: ".jpg"}, append(jpegData, "EICAR"...))
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = sendImage(t, laptopClient, &pb.ImageInfo{LaptopId: laptop.Id, ImageType: ".jpg"}, append(jpegData, "SUSPECT"...))
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	quarantined, err := filepath.Glob(filepath.Join(laptopServer.config.QuarantineFolder, "*.jpg"))
+	require.NoError(t, err)
+	require.Len(t, quarantined, 1)
+	require.FileExists(t, quarantined[0][:len(quarantined[0])-len(".jpg")]+".json")
+
+	images, err := laptopServer.imageStore.List(laptop.Id)
+	require.NoError(t, err)
+	require.Len(t, images, 1)
+}
@@ -0,0 +1,538 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"pc-book/sample"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskImageStoreReload(t *testing.T) {
+	t.Parallel()
+
+	folder := t.TempDir()
+
+	store, err := NewDiskImageStore(folder)
+	require.NoError(t, err)
+
+	imageId, err := store.Save(&ImageInfo{LaptopId: "laptop-1", Type: ".jpg", MimeType: "image/jpeg"}, bytes.NewBufferString("image data"))
+	require.NoError(t, err)
+
+	stray := filepath.Join(folder, "stray.jpg")
+	require.NoError(t, os.WriteFile(stray, []byte("stray"), 0644))
+
+	other, err := NewDiskImageStore(folder)
+	require.NoError(t, err)
+
+	image := other.images[imageId]
+	require.NotNil(t, image)
+	require.Equal(t, "laptop-1", image.LaptopId)
+	require.Equal(t, ".jpg", image.Type)
+	require.Equal(t, "image/jpeg", image.MimeType)
+	require.Equal(t, filepath.Join(folder, "blobs", image.Checksum[:2], image.Checksum[2:4], image.Checksum), image.Path)
+	require.Equal(t, []string{stray}, other.Unattributed())
+}
+
+func TestDiskImageStoreGallery(t *testing.T) {
+	t.Parallel()
+
+	folder := t.TempDir()
+
+	store, err := NewDiskImageStore(folder)
+	require.NoError(t, err)
+
+	imageIds := make([]string, 3)
+	for i := range imageIds {
+		imageIds[i], err = store.Save(&ImageInfo{LaptopId: "laptop-1", Type: ".png"}, bytes.NewBufferString("image data"))
+		require.NoError(t, err)
+	}
+
+	primary, err := store.Primary("laptop-1")
+	require.NoError(t, err)
+	require.Equal(t, imageIds[0], primary.Id)
+
+	images, err := store.Reorder("laptop-1", []string{imageIds[2], imageIds[0], imageIds[1]})
+	require.NoError(t, err)
+	require.Equal(t, imageIds[2], images[0].Id)
+	require.Equal(t, uint32(1), images[1].Position)
+
+	_, err = store.Reorder("laptop-1", []string{imageIds[2], imageIds[2], imageIds[1]})
+	require.ErrorIs(t, err, ErrInvalidImageOrder)
+
+	require.NoError(t, store.SetPrimary("laptop-1", imageIds[1]))
+	require.ErrorIs(t, store.SetPrimary("laptop-2", imageIds[1]), ErrImageNotFound)
+
+	require.NoError(t, store.Delete(imageIds[1]))
+	require.ErrorIs(t, store.Delete(imageIds[1]), ErrImageNotFound)
+
+	reloaded, err := NewDiskImageStore(folder)
+	require.NoError(t, err)
+
+	images, err = reloaded.List("laptop-1")
+	require.NoError(t, err)
+	require.Len(t, images, 2)
+	require.Equal(t, imageIds[2], images[0].Id)
+	require.True(t, images[0].Primary)
+	require.Equal(t, imageIds[0], images[1].Id)
+	require.Equal(t, uint32(1), images[1].Position)
+	require.Empty(t, reloaded.Unattributed())
+
+	imageId, err := reloaded.Save(&ImageInfo{LaptopId: "laptop-1", Type: ".png"}, bytes.NewBufferString("image data"))
+	require.NoError(t, err)
+	otherId, err := reloaded.Save(&ImageInfo{LaptopId: "laptop-2", Type: ".png"}, bytes.NewBufferString("image data"))
+	require.NoError(t, err)
+
+	images, err = reloaded.List("laptop-1")
+	require.NoError(t, err)
+	require.Len(t, images, 3)
+	require.Equal(t, imageId, images[2].Id)
+	require.Equal(t, uint32(2), images[2].Position)
+
+	deleted, err := reloaded.DeleteLaptop("laptop-1")
+	require.NoError(t, err)
+	require.Equal(t, 3, deleted)
+
+	primary, err = reloaded.Primary("laptop-1")
+	require.NoError(t, err)
+	require.Nil(t, primary)
+
+	primary, err = reloaded.Primary("laptop-2")
+	require.NoError(t, err)
+	require.Equal(t, otherId, primary.Id)
+}
+
+func TestImageValidator(t *testing.T) {
+	t.Parallel()
+
+	jpegData, err := os.ReadFile("../img/laptop.jpg")
+	require.NoError(t, err)
+
+	pngData := bytes.Buffer{}
+	require.NoError(t, png.Encode(&pngData, image.NewRGBA(image.Rect(0, 0, 640, 480))))
+
+	validator := NewImageValidator(1024, 1024)
+
+	content, err := validator.Validate(".png", bytes.NewReader(pngData.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, &ImageContent{MimeType: "image/png", Width: 640, Height: 480}, content)
+
+	content, err = validator.Validate(".JPEG", bytes.NewReader(jpegData))
+	require.NoError(t, err)
+	require.Equal(t, "image/jpeg", content.MimeType)
+
+	_, err = validator.Validate(".png", bytes.NewReader(jpegData))
+	require.ErrorIs(t, err, ErrImageTypeMismatch)
+
+	_, err = validator.Validate(".jpg", bytes.NewReader([]byte("MZ\x90\x00 not an image")))
+	require.ErrorIs(t, err, ErrUnsupportedImageType)
+
+	_, err = NewImageValidator(320, 1024).Validate(".png", bytes.NewReader(pngData.Bytes()))
+	require.ErrorIs(t, err, ErrImageTooLarge)
+}
+
+func TestStripImageMetadata(t *testing.T) {
+	t.Parallel()
+
+	jpegData, err := os.ReadFile("../img/laptop.jpg")
+	require.NoError(t, err)
+
+	exif := addJPEGSegment(jpegData, 0xe1, []byte("Exif\x00\x00GPS 52.52N 13.40E"))
+	xmp := addJPEGSegment(exif, 0xe1, []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>"))
+
+	stripped := bytes.Buffer{}
+	removed, err := StripImageMetadata("image/jpeg", &stripped, bytes.NewReader(xmp))
+	require.NoError(t, err)
+	require.Equal(t, 2, removed)
+	require.Equal(t, jpegData, stripped.Bytes())
+
+	pngData := bytes.Buffer{}
+	require.NoError(t, png.Encode(&pngData, image.NewGray(image.Rect(0, 0, 16, 16))))
+
+	text := addPNGChunk(pngData.Bytes(), "tEXt", []byte("Serial\x00SN-1234"))
+	transparent := addPNGChunk(text, "tRNS", []byte{0, 0})
+
+	stripped.Reset()
+	removed, err = StripImageMetadata("image/png", &stripped, bytes.NewReader(transparent))
+	require.NoError(t, err)
+	require.Equal(t, 1, removed)
+	require.NotContains(t, stripped.String(), "SN-1234")
+	require.Contains(t, stripped.String(), "tRNS")
+
+	_, err = png.Decode(bytes.NewReader(stripped.Bytes()))
+	require.NoError(t, err)
+
+	_, err = StripImageMetadata("image/jpeg", io.Discard, bytes.NewReader(jpegData[:100]))
+	require.ErrorIs(t, err, ErrMalformedImage)
+}
+
+func TestStripImageMetadataKeepsOrientation(t *testing.T) {
+	t.Parallel()
+
+	jpegData, err := os.ReadFile("../img/laptop.jpg")
+	require.NoError(t, err)
+
+	// a little endian IFD with a GPS pointer and a 90 degree rotation
+	tiff := []byte("II\x2a\x00\x08\x00\x00\x00")
+	tiff = binary.LittleEndian.AppendUint16(tiff, 2)
+	tiff = append(tiff, 0x25, 0x88, 4, 0, 1, 0, 0, 0, 38, 0, 0, 0)
+	tiff = append(tiff, 0x12, 0x01, 3, 0, 1, 0, 0, 0, 6, 0, 0, 0)
+	tiff = binary.LittleEndian.AppendUint32(tiff, 0)
+	tiff = append(tiff, "GPS 52.52N 13.40E"...)
+
+	exif := addJPEGSegment(jpegData, 0xe1, append([]byte("Exif\x00\x00"), tiff...))
+
+	stripped := bytes.Buffer{}
+	removed, err := StripImageMetadata("image/jpeg", &stripped, bytes.NewReader(exif))
+	require.NoError(t, err)
+	require.Zero(t, removed)
+	require.NotContains(t, stripped.String(), "GPS")
+
+	kept := stripped.Bytes()[2:]
+	require.Equal(t, orientationSegment(6), kept[:len(orientationSegment(6))])
+	require.Equal(t, uint16(6), exifOrientation(kept[4+len(exifHeader):len(orientationSegment(6))]))
+	require.Equal(t, jpegData[2:], kept[len(orientationSegment(6)):])
+
+	_, _, err = image.Decode(bytes.NewReader(stripped.Bytes()))
+	require.NoError(t, err)
+}
+
+// addJPEGSegment inserts a segment right after the start of image marker.
+func addJPEGSegment(jpegData []byte, marker byte, payload []byte) []byte {
+	segment := []byte{0xff, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+
+	data := append([]byte{}, jpegData[:2]...)
+	data = append(data, segment...)
+	data = append(data, payload...)
+
+	return append(data, jpegData[2:]...)
+}
+
+// addPNGChunk inserts a chunk right after the IHDR chunk.
+func addPNGChunk(pngData []byte, chunkType string, payload []byte) []byte {
+	chunk := make([]byte, 8, 12+len(payload))
+	binary.BigEndian.PutUint32(chunk, uint32(len(payload)))
+	copy(chunk[4:], chunkType)
+	chunk = append(chunk, payload...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+
+	// the signature is 8 bytes and IHDR 25
+	data := append([]byte{}, pngData[:33]...)
+	data = append(data, chunk...)
+
+	return append(data, pngData[33:]...)
+}
+
+func TestDiskImageStoreThumbnails(t *testing.T) {
+	t.Parallel()
+
+	folder := t.TempDir()
+
+	store, err := NewDiskImageStoreWithThumbnails(folder, []int{128, 2048})
+	require.NoError(t, err)
+
+	imageData := bytes.Buffer{}
+	require.NoError(t, png.Encode(&imageData, image.NewRGBA(image.Rect(0, 0, 1024, 512))))
+
+	imageId, err := store.Save(&ImageInfo{LaptopId: "laptop-1", Type: ".png", MimeType: "image/png"}, &imageData)
+	require.NoError(t, err)
+
+	other, err := NewDiskImageStoreWithThumbnails(folder, []int{128, 2048})
+	require.NoError(t, err)
+	require.Empty(t, other.Unattributed())
+
+	info, file, err := other.Open(imageId, "thumb_128")
+	require.NoError(t, err)
+	defer file.Close()
+
+	thumbnail, err := png.Decode(file)
+	require.NoError(t, err)
+	require.Equal(t, image.Rect(0, 0, 128, 64), thumbnail.Bounds())
+	require.Len(t, info.Variants, 2)
+	require.Equal(t, uint32(1024), info.Variant("thumb_2048").Width)
+
+	_, _, err = other.Open(imageId, "thumb_64")
+	require.ErrorIs(t, err, ErrImageVariantNotFound)
+
+	require.NoError(t, other.Delete(imageId))
+	requireNoFiles(t, folder)
+}
+
+func TestDiskImageStoreDedup(t *testing.T) {
+	t.Parallel()
+
+	folder := t.TempDir()
+
+	store, err := NewDiskImageStoreWithThumbnails(folder, []int{128})
+	require.NoError(t, err)
+
+	imageData := bytes.Buffer{}
+	require.NoError(t, png.Encode(&imageData, image.NewRGBA(image.Rect(0, 0, 256, 256))))
+
+	checksum := sha256.Sum256(imageData.Bytes())
+
+	_, err = store.Save(&ImageInfo{LaptopId: "laptop-1", Type: ".png", Checksum: "bad"}, bytes.NewReader(imageData.Bytes()))
+	require.ErrorIs(t, err, ErrImageChecksumMismatch)
+
+	imageId1, err := store.Save(&ImageInfo{LaptopId: "laptop-1", Type: ".png", Checksum: hex.EncodeToString(checksum[:])}, bytes.NewReader(imageData.Bytes()))
+	require.NoError(t, err)
+	imageId2, err := store.Save(&ImageInfo{LaptopId: "laptop-2", Type: ".png"}, bytes.NewReader(imageData.Bytes()))
+	require.NoError(t, err)
+
+	image1, err := store.Find(imageId1)
+	require.NoError(t, err)
+	image2, err := store.Find(imageId2)
+	require.NoError(t, err)
+	require.Equal(t, image1.Path, image2.Path)
+	require.Equal(t, image1.Variants, image2.Variants)
+
+	reloaded, err := NewDiskImageStoreWithThumbnails(folder, []int{128})
+	require.NoError(t, err)
+
+	deleted, err := reloaded.DeleteLaptop("laptop-1")
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+	require.FileExists(t, image2.Path)
+	require.FileExists(t, image2.Variants[0].Path)
+
+	deleted, err = reloaded.DeleteLaptop("laptop-2")
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+	requireNoFiles(t, folder)
+}
+
+func TestDiskImageStoreSaveFile(t *testing.T) {
+	t.Parallel()
+
+	folder := t.TempDir()
+
+	store, err := NewDiskImageStore(folder)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "upload.tmp")
+	require.NoError(t, os.WriteFile(path, []byte("image data"), 0644))
+
+	_, err = store.SaveFile(&ImageInfo{LaptopId: "laptop-1", Type: ".jpg", Checksum: "bad"}, path)
+	require.ErrorIs(t, err, ErrImageChecksumMismatch)
+
+	imageId, err := store.SaveFile(&ImageInfo{LaptopId: "laptop-1", Type: ".jpg"}, path)
+	require.NoError(t, err)
+
+	image, err := store.Find(imageId)
+	require.NoError(t, err)
+	require.Equal(t, uint64(len("image data")), image.Size)
+
+	// the file is linked into the store rather than copied
+	fileInfo, err := os.Stat(path)
+	require.NoError(t, err)
+	blobInfo, err := os.Stat(image.Path)
+	require.NoError(t, err)
+	require.True(t, os.SameFile(fileInfo, blobInfo))
+
+	require.NoError(t, os.Remove(path))
+	data, err := os.ReadFile(image.Path)
+	require.NoError(t, err)
+	require.Equal(t, []byte("image data"), data)
+
+	require.NoError(t, store.Delete(imageId))
+	requireNoFiles(t, folder)
+}
+
+func TestDiskImageStoreKeepsLinkedBlob(t *testing.T) {
+	t.Parallel()
+
+	store, err := NewDiskImageStoreWithThumbnails(t.TempDir(), []int{128})
+	require.NoError(t, err)
+
+	// the blob linked by a concurrent save of the same content
+	imageData := []byte("not an image")
+	checksum := sha256.Sum256(imageData)
+	path := store.blobPath(hex.EncodeToString(checksum[:]))
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, imageData, 0644))
+
+	_, err = store.Save(&ImageInfo{LaptopId: "laptop-1", Type: ".png"}, bytes.NewReader(imageData))
+	require.Error(t, err)
+	require.FileExists(t, path)
+}
+
+func TestDiskImageStoreMigrateLegacyFiles(t *testing.T) {
+	t.Parallel()
+
+	folder := t.TempDir()
+
+	data := []byte("legacy image data")
+	checksum := sha256.Sum256(data)
+	legacy := &ImageInfo{Id: "image-1", LaptopId: "laptop-1", Type: ".jpg", Size: uint64(len(data)), Checksum: hex.EncodeToString(checksum[:])}
+
+	metadata, err := json.Marshal(legacy)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(folder, "image-1"+IMAGE_METADATA_EXT), metadata, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(folder, "image-1.jpg"), data, 0644))
+
+	store, err := NewDiskImageStore(folder)
+	require.NoError(t, err)
+	require.Empty(t, store.Unattributed())
+
+	image, err := store.Find("image-1")
+	require.NoError(t, err)
+	require.NotNil(t, image)
+	require.NoFileExists(t, filepath.Join(folder, "image-1.jpg"))
+
+	stored, err := os.ReadFile(image.Path)
+	require.NoError(t, err)
+	require.Equal(t, data, stored)
+}
+
+func requireNoFiles(t *testing.T, folder string) {
+	err := filepath.WalkDir(folder, func(path string, entry fs.DirEntry, err error) error {
+		require.NoError(t, err)
+		require.True(t, entry.IsDir(), "unexpected file %s", path)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestImageCollector(t *testing.T) {
+	t.Parallel()
+
+	folder := t.TempDir()
+
+	previous, err := NewDiskImageStore(folder)
+	require.NoError(t, err)
+	deleted, err := previous.Save(&ImageInfo{LaptopId: "laptop-1", Type: ".jpg"}, bytes.NewBufferString("deleted"))
+	require.NoError(t, err)
+
+	store, err := NewDiskImageStore(folder)
+	require.NoError(t, err)
+	require.NoError(t, store.Delete(deleted))
+
+	laptopStore := NewInMemoryLaptopStore()
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopStore.Save(laptop))
+
+	kept, err := store.Save(&ImageInfo{LaptopId: laptop.Id, Type: ".jpg"}, bytes.NewBufferString("kept"))
+	require.NoError(t, err)
+	orphaned, err := store.Save(&ImageInfo{LaptopId: "deleted-laptop", Type: ".jpg"}, bytes.NewBufferString("orphaned"))
+	require.NoError(t, err)
+
+	// a legacy file of an image the store knew, and image files it cannot attribute
+	stray := filepath.Join(folder, deleted+"_thumb_128.jpg")
+	require.NoError(t, os.WriteFile(stray, []byte("stray"), 0644))
+	unrelated := filepath.Join(folder, "laptop.bin")
+	require.NoError(t, os.WriteFile(unrelated, []byte("laptop"), 0644))
+	foreign := filepath.Join(folder, "photo.jpg")
+	require.NoError(t, os.WriteFile(foreign, []byte("photo"), 0644))
+	unknown := filepath.Join(folder, uuid.NewString()+".jpg")
+	require.NoError(t, os.WriteFile(unknown, []byte("unknown"), 0644))
+
+	collector := NewImageCollector(store, laptopStore, time.Hour, true)
+	report, err := collector.Collect()
+	require.NoError(t, err)
+	require.Zero(t, report.OrphanFiles)
+	require.Zero(t, report.OrphanImages)
+
+	old := time.Now().Add(-2 * time.Hour)
+	for _, path := range []string{stray, foreign, unknown} {
+		require.NoError(t, os.Chtimes(path, old, old))
+	}
+	store.images[orphaned].CreatedAt = old
+	store.images[kept].CreatedAt = old
+
+	reporter := NewImageCollector(store, laptopStore, time.Hour, false)
+	for _, c := range []*ImageCollector{collector, reporter} {
+		c.LaptopDeleted("deleted-laptop")
+		c.deletedLaptops["deleted-laptop"] = old
+	}
+
+	report, err = reporter.Collect()
+	require.NoError(t, err)
+	require.Equal(t, 1, report.OrphanFiles)
+	require.Equal(t, int64(len("stray")), report.OrphanBytes)
+	require.Equal(t, 1, report.OrphanImages)
+	require.Zero(t, report.DeletedFiles)
+	require.Zero(t, report.DeletedImages)
+	require.FileExists(t, stray)
+	require.Same(t, report, reporter.LastReport())
+
+	report, err = collector.Collect()
+	require.NoError(t, err)
+	require.Equal(t, 1, report.DeletedFiles)
+	require.Equal(t, 1, report.DeletedImages)
+	require.NoFileExists(t, stray)
+	require.FileExists(t, unrelated)
+	require.FileExists(t, foreign)
+	require.FileExists(t, unknown)
+
+	image, err := store.Find(orphaned)
+	require.NoError(t, err)
+	require.Nil(t, image)
+	image, err = store.Find(kept)
+	require.NoError(t, err)
+	require.NotNil(t, image)
+	require.FileExists(t, image.Path)
+	require.Empty(t, collector.deletedLaptops)
+}
+
+func TestImageCollectorAfterRestart(t *testing.T) {
+	t.Parallel()
+
+	folder := t.TempDir()
+
+	store, err := NewDiskImageStore(folder)
+	require.NoError(t, err)
+
+	imageId, err := store.Save(&ImageInfo{LaptopId: "laptop-1", Type: ".jpg"}, bytes.NewBufferString("image"))
+	require.NoError(t, err)
+
+	// the laptops are gone after a restart, but their images are not orphans
+	restarted, err := NewDiskImageStore(folder)
+	require.NoError(t, err)
+	restarted.images[imageId].CreatedAt = time.Now().Add(-2 * time.Hour)
+
+	collector := NewImageCollector(restarted, NewInMemoryLaptopStore(), time.Hour, true)
+	report, err := collector.Collect()
+	require.NoError(t, err)
+	require.Zero(t, report.OrphanImages)
+	require.Zero(t, report.DeletedImages)
+
+	image, err := restarted.Find(imageId)
+	require.NoError(t, err)
+	require.NotNil(t, image)
+	require.FileExists(t, image.Path)
+}
+
+func TestResizeImage(t *testing.T) {
+	t.Parallel()
+
+	// a 6x4 gray image offset by its bounds, whose left half is 0 and right half is 200
+	src := image.NewGray(image.Rect(10, 10, 16, 14))
+	for y := 10; y < 14; y++ {
+		for x := 13; x < 16; x++ {
+			src.SetGray(x, y, color.Gray{Y: 200})
+		}
+	}
+
+	dst := resizeImage(src, 2, 1)
+	require.Equal(t, image.Rect(0, 0, 2, 1), dst.Bounds())
+	require.Equal(t, color.RGBA{0, 0, 0, 255}, dst.RGBAAt(0, 0))
+	require.Equal(t, color.RGBA{200, 200, 200, 255}, dst.RGBAAt(1, 0))
+
+	dst = resizeImage(src, 3, 2)
+	require.Equal(t, color.RGBA{100, 100, 100, 255}, dst.RGBAAt(1, 1))
+}
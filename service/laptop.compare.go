@@ -0,0 +1,216 @@
+package service
+
+import (
+	"context"
+	"log"
+	"pc-book/pb"
+	"pc-book/units"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	MIN_COMPARE_LAPTOPS = 2
+	MAX_COMPARE_LAPTOPS = 4
+)
+
+type compareAttribute struct {
+	name           string
+	unit           string
+	higherIsBetter bool
+	value          func(laptop *pb.Laptop) float64
+}
+
+var compareAttributes = []compareAttribute{
+	{
+		name:           "cpu.cores",
+		unit:           "cores",
+		higherIsBetter: true,
+		value: func(laptop *pb.Laptop) float64 {
+			return float64(laptop.GetCpu().GetCoresMunber())
+		},
+	},
+	{
+		name:           "cpu.threads",
+		unit:           "threads",
+		higherIsBetter: true,
+		value: func(laptop *pb.Laptop) float64 {
+			return float64(laptop.GetCpu().GetThreadsNumber())
+		},
+	},
+	{
+		name:           "cpu.min_freq",
+		unit:           "GHz",
+		higherIsBetter: true,
+		value: func(laptop *pb.Laptop) float64 {
+			return laptop.GetCpu().GetMinFreq()
+		},
+	},
+	{
+		name:           "cpu.max_freq",
+		unit:           "GHz",
+		higherIsBetter: true,
+		value: func(laptop *pb.Laptop) float64 {
+			return laptop.GetCpu().GetMaxFreq()
+		},
+	},
+	{
+		name:           "memory",
+		unit:           "GB",
+		higherIsBetter: true,
+		value: func(laptop *pb.Laptop) float64 {
+			return units.ToGigabytes(laptop.GetMemory())
+		},
+	},
+	{
+		name:           "gpu.memory",
+		unit:           "GB",
+		higherIsBetter: true,
+		value: func(laptop *pb.Laptop) float64 {
+			total := 0.0
+			for _, gpu := range laptop.GetGpus() {
+				total += units.ToGigabytes(gpu.GetMemory())
+			}
+			return total
+		},
+	},
+	{
+		name:           "gpu.max_freq",
+		unit:           "GHz",
+		higherIsBetter: true,
+		value: func(laptop *pb.Laptop) float64 {
+			max := 0.0
+			for _, gpu := range laptop.GetGpus() {
+				if gpu.GetMaxFreq() > max {
+					max = gpu.GetMaxFreq()
+				}
+			}
+			return max
+		},
+	},
+	{
+		name:           "storage",
+		unit:           "GB",
+		higherIsBetter: true,
+		value: func(laptop *pb.Laptop) float64 {
+			total := 0.0
+			for _, storage := range laptop.GetStorages() {
+				total += units.ToGigabytes(storage.GetMemory())
+			}
+			return total
+		},
+	},
+	{
+		name:           "screen.size",
+		unit:           "inch",
+		higherIsBetter: true,
+		value: func(laptop *pb.Laptop) float64 {
+			return float64(laptop.GetScreen().GetSizeInch())
+		},
+	},
+	{
+		name:           "weight",
+		unit:           "kg",
+		higherIsBetter: false,
+		value: func(laptop *pb.Laptop) float64 {
+			return units.WeightKg(laptop)
+		},
+	},
+	{
+		name:           "price",
+		unit:           "USD",
+		higherIsBetter: false,
+		value: func(laptop *pb.Laptop) float64 {
+			return laptop.GetPriceUsd()
+		},
+	},
+	{
+		name:           "release_year",
+		unit:           "year",
+		higherIsBetter: true,
+		value: func(laptop *pb.Laptop) float64 {
+			return float64(laptop.GetReleaseYear())
+		},
+	},
+}
+
+func (server *LaptopServer) CompareLaptops(ctx context.Context, req *pb.CompareLaptopsRequest) (*pb.CompareLaptopsResponse, error) {
+	laptopIds := req.GetLaptopIds()
+
+	log.Printf("receive a compare laptops request with ids: %v", laptopIds)
+
+	if len(laptopIds) < MIN_COMPARE_LAPTOPS || len(laptopIds) > MAX_COMPARE_LAPTOPS {
+		return nil, status.Errorf(codes.InvalidArgument, "can only compare %d to %d laptops", MIN_COMPARE_LAPTOPS, MAX_COMPARE_LAPTOPS)
+	}
+
+	laptops := make([]*pb.Laptop, 0, len(laptopIds))
+	seen := make(map[string]bool)
+	for _, id := range laptopIds {
+		if seen[id] {
+			return nil, status.Errorf(codes.InvalidArgument, "duplicate laptop id: %s", id)
+		}
+		seen[id] = true
+
+		laptop, err := server.laptopStore.Find(id)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "cannot find laptop: %v", err)
+		}
+		if laptop == nil {
+			return nil, status.Errorf(codes.NotFound, "laptop %s does not exist", id)
+		}
+
+		laptops = append(laptops, laptop)
+	}
+
+	err := contexError(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.CompareLaptopsResponse{
+		Laptops:    laptops,
+		Attributes: compareLaptops(laptops),
+	}, nil
+}
+
+func compareLaptops(laptops []*pb.Laptop) []*pb.AttributeComparison {
+	comparisons := make([]*pb.AttributeComparison, 0, len(compareAttributes))
+
+	for _, attr := range compareAttributes {
+		values := make([]float64, len(laptops))
+		for i, laptop := range laptops {
+			values[i] = attr.value(laptop)
+		}
+
+		best := values[0]
+		allEqual := true
+		for _, value := range values[1:] {
+			if value != values[0] {
+				allEqual = false
+			}
+			if (attr.higherIsBetter && value > best) || (!attr.higherIsBetter && value < best) {
+				best = value
+			}
+		}
+
+		var winnerIds []string
+		if !allEqual {
+			for i, value := range values {
+				if value == best {
+					winnerIds = append(winnerIds, laptops[i].GetId())
+				}
+			}
+		}
+
+		comparisons = append(comparisons, &pb.AttributeComparison{
+			Name:      attr.name,
+			Unit:      attr.unit,
+			Values:    values,
+			WinnerIds: winnerIds,
+			AllEqual:  allEqual,
+		})
+	}
+
+	return comparisons
+}
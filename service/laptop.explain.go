@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"log"
+	"pc-book/pb"
+	"pc-book/units"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	DEFAULT_EXPLAIN_SAMPLE_SIZE = 10
+	MAX_EXPLAIN_SAMPLE_SIZE     = 100
+)
+
+func (server *LaptopServer) ExplainSearch(ctx context.Context, req *pb.ExplainSearchRequest) (*pb.ExplainSearchResponse, error) {
+	filter := req.GetFilter()
+
+	log.Printf("receive an explain-search request for laptop %q with filter: %v", req.GetLaptopId(), filter)
+
+	err := validateFilter(filter)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid filter: %v", err)
+	}
+
+	var laptops []*pb.Laptop
+	if len(req.GetLaptopId()) > 0 {
+		laptop, err := server.laptopStore.Find(req.GetLaptopId())
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "cannot find laptop: %v", err)
+		}
+		if laptop == nil {
+			return nil, status.Errorf(codes.NotFound, "laptop %s does not exist", req.GetLaptopId())
+		}
+
+		laptops = []*pb.Laptop{laptop}
+	} else {
+		sampleSize := int(req.GetSampleSize())
+		if sampleSize == 0 {
+			sampleSize = DEFAULT_EXPLAIN_SAMPLE_SIZE
+		}
+		if sampleSize > MAX_EXPLAIN_SAMPLE_SIZE {
+			sampleSize = MAX_EXPLAIN_SAMPLE_SIZE
+		}
+
+		laptops, err = server.laptopStore.Sample(sampleSize)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "cannot sample laptops: %v", err)
+		}
+	}
+
+	err = contexError(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &pb.ExplainSearchResponse{}
+	for _, laptop := range laptops {
+		explanation := explainLaptop(filter, laptop)
+
+		result := server.explainMaxPrice(filter, laptop)
+		if result != nil {
+			explanation.Clauses = append([]*pb.ClauseResult{result}, explanation.Clauses...)
+			explanation.Qualified = explanation.Qualified && result.Passed
+		}
+
+		result = server.explainMinAverageRating(filter, laptop)
+		if result != nil {
+			explanation.Clauses = append(explanation.Clauses, result)
+			explanation.Qualified = explanation.Qualified && result.Passed
+		}
+
+		res.Explanations = append(res.Explanations, explanation)
+	}
+
+	return res, nil
+}
+
+// explainMaxPrice checks the currency-aware max_price clause, which is applied by the server rather than the store.
+func (server *LaptopServer) explainMaxPrice(filter *pb.FilterMessage, laptop *pb.Laptop) *pb.ClauseResult {
+	maxPrice := filter.GetMaxPrice()
+	if maxPrice == nil {
+		return nil
+	}
+
+	price, err := server.convertPrice(laptopPrice(laptop), maxPrice.GetCurrencyCode())
+	if err != nil {
+		return &pb.ClauseResult{
+			Clause:   "max_price",
+			Required: units.FormatMoney(maxPrice),
+			Message:  "price cannot be converted: " + err.Error(),
+		}
+	}
+
+	actual := units.MoneyToFloat(price)
+	required := units.MoneyToFloat(maxPrice)
+
+	return newClauseResult("max_price", "price", units.FormatMoney(price), units.FormatMoney(maxPrice), actual <= required, "<=", ">")
+}
+
+// explainMinAverageRating checks the min_average_rating clause, which is applied by the server rather than the store.
+func (server *LaptopServer) explainMinAverageRating(filter *pb.FilterMessage, laptop *pb.Laptop) *pb.ClauseResult {
+	minAverage := filter.GetMinAverageRating()
+	if minAverage <= 0 {
+		return nil
+	}
+
+	summary, err := server.ratingSummary(laptop.GetId())
+	if err != nil {
+		return &pb.ClauseResult{
+			Clause:   "min_average_rating",
+			Required: formatFloat(minAverage),
+			Message:  "rating cannot be summarized: " + err.Error(),
+		}
+	}
+	if summary.Count == 0 {
+		return &pb.ClauseResult{
+			Clause:   "min_average_rating",
+			Required: formatFloat(minAverage),
+			Message:  "laptop is not rated",
+		}
+	}
+
+	return newClauseResult("min_average_rating", "average rating", formatFloat(summary.Average), formatFloat(minAverage), summary.Average >= minAverage, ">=", "<")
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"fmt"
+	"pc-book/pb"
+	"pc-book/units"
+)
+
+// filterClause checks one criterion of a FilterMessage against a laptop.
+// It returns nil when the criterion does not apply to the filter.
+type filterClause func(filter *pb.FilterMessage, laptop *pb.Laptop) *pb.ClauseResult
+
+var filterClauses = []filterClause{
+	func(filter *pb.FilterMessage, laptop *pb.Laptop) *pb.ClauseResult {
+		if filter.GetMaxPrice() != nil {
+			return nil
+		}
+
+		return maxClause("max_price_usd", "price_usd", laptop.GetPriceUsd(), filter.GetMaxPriceUsd())
+	},
+	func(filter *pb.FilterMessage, laptop *pb.Laptop) *pb.ClauseResult {
+		return minClause("cpu_cores", "cpu.cores_number", float64(laptop.GetCpu().GetCoresMunber()), float64(filter.GetCpuCores()))
+	},
+	func(filter *pb.FilterMessage, laptop *pb.Laptop) *pb.ClauseResult {
+		return minClause("mix_cpu_ghz", "cpu.min_freq", laptop.GetCpu().GetMinFreq(), filter.GetMixCpuGhz())
+	},
+	func(filter *pb.FilterMessage, laptop *pb.Laptop) *pb.ClauseResult {
+		if filter.GetMinRam() == nil {
+			return nil
+		}
+
+		actual := units.FormatMemory(laptop.GetMemory())
+		required := units.FormatMemory(filter.GetMinRam())
+
+		cmp, err := units.CompareMemory(laptop.GetMemory(), filter.GetMinRam())
+		if err != nil {
+			return &pb.ClauseResult{
+				Clause:   "min_ram",
+				Actual:   actual,
+				Required: required,
+				Message:  fmt.Sprintf("memory cannot be compared: %v", err),
+			}
+		}
+
+		return newClauseResult("min_ram", "memory", actual, required, cmp >= 0, ">=", "<")
+	},
+	func(filter *pb.FilterMessage, laptop *pb.Laptop) *pb.ClauseResult {
+		return minClause("min_performance_score", "performance_score", laptop.GetPerformanceScore(), filter.GetMinPerformanceScore())
+	},
+	func(filter *pb.FilterMessage, laptop *pb.Laptop) *pb.ClauseResult {
+		return minClause("min_value_score", "value_score", laptop.GetValueScore(), filter.GetMinValueScore())
+	},
+}
+
+func minClause(clause, field string, actual, required float64) *pb.ClauseResult {
+	return newClauseResult(clause, field, formatFloat(actual), formatFloat(required), actual >= required, ">=", "<")
+}
+
+func maxClause(clause, field string, actual, required float64) *pb.ClauseResult {
+	return newClauseResult(clause, field, formatFloat(actual), formatFloat(required), actual <= required, "<=", ">")
+}
+
+func newClauseResult(clause, field, actual, required string, passed bool, passOp, failOp string) *pb.ClauseResult {
+	op := failOp
+	if passed {
+		op = passOp
+	}
+
+	return &pb.ClauseResult{
+		Clause:   clause,
+		Passed:   passed,
+		Actual:   actual,
+		Required: required,
+		Message:  fmt.Sprintf("%s %s %s required %s", field, actual, op, required),
+	}
+}
+
+func formatFloat(value float64) string {
+	return fmt.Sprintf("%.4g", value)
+}
+
+// explainLaptop reports every applicable filter clause for a laptop.
+func explainLaptop(filter *pb.FilterMessage, laptop *pb.Laptop) *pb.LaptopExplanation {
+	explanation := &pb.LaptopExplanation{
+		LaptopId:  laptop.GetId(),
+		Qualified: true,
+	}
+
+	for _, clause := range filterClauses {
+		result := clause(filter, laptop)
+		if result == nil {
+			continue
+		}
+
+		explanation.Clauses = append(explanation.Clauses, result)
+		if !result.Passed {
+			explanation.Qualified = false
+		}
+	}
+
+	return explanation
+}
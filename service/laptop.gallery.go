@@ -0,0 +1,200 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"pc-book/pb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (server *LaptopServer) ListLaptopImages(ctx context.Context, req *pb.ListLaptopImagesRequest) (*pb.ListLaptopImagesResponse, error) {
+	log.Printf("receive a list-laptop-images request for laptop %s", req.GetLaptopId())
+
+	err := server.checkGallery(req.GetLaptopId())
+	if err != nil {
+		return nil, err
+	}
+
+	images, err := server.imageStore.List(req.GetLaptopId())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot list images: %v", err)
+	}
+
+	return &pb.ListLaptopImagesResponse{
+		Images: toImageInfoMessages(images),
+	}, nil
+}
+
+func (server *LaptopServer) DeleteImage(ctx context.Context, req *pb.DeleteImageRequest) (*pb.DeleteImageResponse, error) {
+	log.Printf("receive a delete-image request for image %s", req.GetImageId())
+
+	if server.imageStore == nil {
+		return nil, errNoImageStore()
+	}
+
+	err := server.imageStore.Delete(req.GetImageId())
+	if err != nil {
+		return nil, imageStoreError("cannot delete image", err)
+	}
+
+	return &pb.DeleteImageResponse{}, nil
+}
+
+func (server *LaptopServer) SetPrimaryImage(ctx context.Context, req *pb.SetPrimaryImageRequest) (*pb.SetPrimaryImageResponse, error) {
+	log.Printf("receive a set-primary-image request for laptop %s, image %s", req.GetLaptopId(), req.GetImageId())
+
+	err := server.checkGallery(req.GetLaptopId())
+	if err != nil {
+		return nil, err
+	}
+
+	err = server.imageStore.SetPrimary(req.GetLaptopId(), req.GetImageId())
+	if err != nil {
+		return nil, imageStoreError("cannot set primary image", err)
+	}
+
+	return &pb.SetPrimaryImageResponse{}, nil
+}
+
+func (server *LaptopServer) ReorderImages(ctx context.Context, req *pb.ReorderImagesRequest) (*pb.ReorderImagesResponse, error) {
+	log.Printf("receive a reorder-images request for laptop %s: %v", req.GetLaptopId(), req.GetImageIds())
+
+	err := server.checkGallery(req.GetLaptopId())
+	if err != nil {
+		return nil, err
+	}
+
+	images, err := server.imageStore.Reorder(req.GetLaptopId(), req.GetImageIds())
+	if err != nil {
+		return nil, imageStoreError("cannot reorder images", err)
+	}
+
+	return &pb.ReorderImagesResponse{
+		Images: toImageInfoMessages(images),
+	}, nil
+}
+
+// checkGallery makes sure the gallery of a laptop can be managed: the server has an image store
+// and the laptop exists.
+func (server *LaptopServer) checkGallery(laptopId string) error {
+	if server.imageStore == nil {
+		return errNoImageStore()
+	}
+
+	laptop, err := server.laptopStore.Find(laptopId)
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot find laptop: %v", err)
+	}
+	if laptop == nil {
+		return status.Errorf(codes.NotFound, "laptop %s does not exist", laptopId)
+	}
+
+	return nil
+}
+
+func errNoImageStore() error {
+	return status.Errorf(codes.FailedPrecondition, "images are not enabled")
+}
+
+// attachPrimaryImage sets the primary image id of a found laptop.
+func (server *LaptopServer) attachPrimaryImage(laptop *pb.Laptop) error {
+	if server.imageStore == nil {
+		return nil
+	}
+
+	image, err := server.imageStore.Primary(laptop.GetId())
+	if err != nil {
+		return err
+	}
+	if image != nil {
+		laptop.PrimaryImageId = image.Id
+	}
+
+	return nil
+}
+
+func (server *LaptopServer) GetImageURL(ctx context.Context, req *pb.GetImageURLRequest) (*pb.GetImageURLResponse, error) {
+	imageId, variant := req.GetImageId(), req.GetVariant()
+
+	log.Printf("receive a get-image-url request for image %s, variant %q", imageId, variant)
+
+	signer := server.config.ImageURLSigner
+	if signer == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "signed image urls are not enabled")
+	}
+
+	image, err := server.imageStore.Find(imageId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find image: %v", err)
+	}
+	if image == nil {
+		return nil, status.Errorf(codes.NotFound, "image %s does not exist", imageId)
+	}
+	if variant != "" && image.Variant(variant) == nil {
+		return nil, status.Errorf(codes.NotFound, "%v: %s of image %s", ErrImageVariantNotFound, variant, imageId)
+	}
+
+	url, expiresAt := signer.Sign(imageId, variant)
+
+	return &pb.GetImageURLResponse{
+		Url:       url,
+		ExpiresAt: timestamppb.New(expiresAt),
+	}, nil
+}
+
+func imageStoreError(message string, err error) error {
+	code := codes.Internal
+	switch {
+	case errors.Is(err, ErrImageNotFound), errors.Is(err, ErrImageVariantNotFound):
+		code = codes.NotFound
+	case errors.Is(err, ErrInvalidImageOrder):
+		code = codes.InvalidArgument
+	}
+
+	return status.Errorf(code, "%s: %v", message, err)
+}
+
+func toImageInfoMessage(image *ImageInfo) *pb.ImageInfo {
+	return &pb.ImageInfo{
+		LaptopId:         image.LaptopId,
+		ImageType:        image.Type,
+		ImageId:          image.Id,
+		Size:             image.Size,
+		Checksum:         image.Checksum,
+		Position:         image.Position,
+		Primary:          image.Primary,
+		MimeType:         image.MimeType,
+		Width:            image.Width,
+		Height:           image.Height,
+		Variants:         toImageVariantMessages(image.Variants),
+		MetadataStripped: image.MetadataStripped,
+	}
+}
+
+func toImageVariantMessages(variants []*ImageVariant) []*pb.ImageVariant {
+	messages := make([]*pb.ImageVariant, len(variants))
+	for i, variant := range variants {
+		messages[i] = &pb.ImageVariant{
+			Name:     variant.Name,
+			Width:    variant.Width,
+			Height:   variant.Height,
+			Size:     variant.Size,
+			Checksum: variant.Checksum,
+		}
+	}
+
+	return messages
+}
+
+func toImageInfoMessages(images []*ImageInfo) []*pb.ImageInfo {
+	messages := make([]*pb.ImageInfo, len(images))
+	for i, image := range images {
+		messages[i] = toImageInfoMessage(image)
+	}
+
+	return messages
+}
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"context"
+	"log"
+	"pc-book/pb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (server *LaptopServer) GetImageGCStats(ctx context.Context, req *pb.GetImageGCStatsRequest) (*pb.GetImageGCStatsResponse, error) {
+	log.Printf("receive a get-image-gc-stats request, run: %t", req.GetRun())
+
+	collector := server.config.ImageCollector
+	if collector == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "image garbage collection is not enabled")
+	}
+
+	report := collector.LastReport()
+	if req.GetRun() {
+		var err error
+		report, err = collector.Collect()
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "cannot collect image garbage: %v", err)
+		}
+	}
+
+	return &pb.GetImageGCStatsResponse{
+		Stats: toImageGCStatsMessage(report, collector.delete),
+	}, nil
+}
+
+func toImageGCStatsMessage(report *ImageGCReport, deleting bool) *pb.ImageGCStats {
+	if report == nil {
+		return &pb.ImageGCStats{DeleteEnabled: deleting}
+	}
+
+	return &pb.ImageGCStats{
+		LastRun:       timestamppb.New(report.StartedAt),
+		ScannedFiles:  uint32(report.ScannedFiles),
+		OrphanFiles:   uint32(report.OrphanFiles),
+		OrphanBytes:   uint64(report.OrphanBytes),
+		OrphanImages:  uint32(report.OrphanImages),
+		DeletedFiles:  uint32(report.DeletedFiles),
+		DeletedImages: uint32(report.DeletedImages),
+		DeleteEnabled: report.Deleting,
+	}
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"pc-book/pb"
+	"pc-book/units"
+	"strings"
+)
+
+const DEFAULT_CURRENCY = "USD"
+
+// laptopPrice returns the original price of a laptop, falling back to price_usd for laptops saved without one.
+func laptopPrice(laptop *pb.Laptop) *pb.Money {
+	if laptop.GetPrice() != nil {
+		return laptop.GetPrice()
+	}
+
+	return units.NewMoney(DEFAULT_CURRENCY, laptop.GetPriceUsd())
+}
+
+func (server *LaptopServer) convertPrice(price *pb.Money, currencyCode string) (*pb.Money, error) {
+	if server.exchangeRates == nil {
+		if !strings.EqualFold(price.GetCurrencyCode(), currencyCode) {
+			return nil, fmt.Errorf("%w: no exchange rates to convert %s to %s", ErrUnknownCurrency, price.GetCurrencyCode(), currencyCode)
+		}
+
+		return units.NewMoney(currencyCode, units.MoneyToFloat(price)), nil
+	}
+
+	return server.exchangeRates.Convert(price, currencyCode)
+}
+
+// normalizePrice makes sure both price and price_usd of a new laptop are set.
+func (server *LaptopServer) normalizePrice(laptop *pb.Laptop) error {
+	if laptop.GetPrice() == nil {
+		laptop.Price = units.NewMoney(DEFAULT_CURRENCY, laptop.GetPriceUsd())
+		return nil
+	}
+
+	priceUsd, err := server.convertPrice(laptop.GetPrice(), DEFAULT_CURRENCY)
+	if err != nil {
+		return err
+	}
+
+	laptop.PriceUsd = units.MoneyToFloat(priceUsd)
+
+	return nil
+}
+
+// searchCurrency returns the currency search results are converted to and the max price in that currency.
+func (server *LaptopServer) searchCurrency(req *pb.SearchLaptopRequest) (string, *pb.Money, error) {
+	maxPrice := req.GetFilter().GetMaxPrice()
+
+	currencyCode := strings.ToUpper(req.GetCurrencyCode())
+	if currencyCode == "" && maxPrice != nil {
+		currencyCode = strings.ToUpper(maxPrice.GetCurrencyCode())
+	}
+	if currencyCode == "" {
+		currencyCode = DEFAULT_CURRENCY
+	}
+
+	if maxPrice == nil {
+		return currencyCode, nil, nil
+	}
+
+	maxPrice, err := server.convertPrice(maxPrice, currencyCode)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return currencyCode, maxPrice, nil
+}
+
+// attachConvertedPrice sets the converted price of a found laptop and reports whether it is within maxPrice.
+func (server *LaptopServer) attachConvertedPrice(laptop *pb.Laptop, currencyCode string, maxPrice *pb.Money) bool {
+	price, err := server.convertPrice(laptopPrice(laptop), currencyCode)
+	if err != nil {
+		log.Printf("cannot convert price of laptop %s: %v", laptop.GetId(), err)
+		return false
+	}
+
+	if maxPrice != nil && units.MoneyToFloat(price) > units.MoneyToFloat(maxPrice) {
+		return false
+	}
+
+	laptop.Price = laptopPrice(laptop)
+	laptop.ConvertedPrice = price
+
+	return true
+}
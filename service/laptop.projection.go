@@ -0,0 +1,68 @@
+package service
+
+import (
+	"fmt"
+	"pc-book/pb"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// maskTree is a read mask split into a tree of field names. An empty subtree keeps the whole field.
+type maskTree map[string]maskTree
+
+func validateReadMask(mask *fieldmaskpb.FieldMask) error {
+	if mask != nil && !mask.IsValid(&pb.Laptop{}) {
+		return fmt.Errorf("invalid read mask paths: %v", mask.GetPaths())
+	}
+
+	return nil
+}
+
+func newMaskTree(mask *fieldmaskpb.FieldMask) maskTree {
+	tree := maskTree{}
+	for _, path := range mask.GetPaths() {
+		node := tree
+		for _, name := range strings.Split(path, ".") {
+			child, ok := node[name]
+			if !ok {
+				child = maskTree{}
+				node[name] = child
+			}
+			node = child
+		}
+	}
+
+	return tree
+}
+
+// applyReadMask prunes a laptop down to the fields listed in the read mask.
+// A nil or empty mask keeps the laptop untouched.
+func applyReadMask(laptop *pb.Laptop, mask *fieldmaskpb.FieldMask) {
+	if len(mask.GetPaths()) == 0 {
+		return
+	}
+
+	newMaskTree(mask).prune(laptop.ProtoReflect())
+}
+
+func (tree maskTree) prune(msg protoreflect.Message) {
+	if len(tree) == 0 {
+		return
+	}
+
+	msg.Range(func(field protoreflect.FieldDescriptor, value protoreflect.Value) bool {
+		subtree, ok := tree[string(field.Name())]
+		if !ok {
+			msg.Clear(field)
+			return true
+		}
+
+		if field.Message() != nil && !field.IsList() && !field.IsMap() {
+			subtree.prune(value.Message())
+		}
+
+		return true
+	})
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"pc-book/pb"
+	"sort"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (server *LaptopServer) GetMyRating(ctx context.Context, req *pb.GetMyRatingRequest) (*pb.GetMyRatingResponse, error) {
+	username, err := ratingUsername(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("receive a get-my-rating request for laptop %s by %s", req.GetLaptopId(), username)
+
+	rating, err := server.ratingStore.Find(req.GetLaptopId(), username)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find rating: %v", err)
+	}
+	if rating == nil {
+		return nil, status.Errorf(codes.NotFound, "laptop %s is not rated by %s", req.GetLaptopId(), username)
+	}
+
+	return &pb.GetMyRatingResponse{
+		LaptopId: rating.LaptopId,
+		Score:    rating.Score,
+		RatedAt:  timestamppb.New(rating.RatedAt),
+	}, nil
+}
+
+func (server *LaptopServer) DeleteMyRating(ctx context.Context, req *pb.DeleteMyRatingRequest) (*pb.DeleteMyRatingResponse, error) {
+	username, err := ratingUsername(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("receive a delete-my-rating request for laptop %s by %s", req.GetLaptopId(), username)
+
+	rating, err := server.ratingStore.Delete(req.GetLaptopId(), username)
+	if errors.Is(err, ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, "%v", err)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot delete rating: %v", err)
+	}
+
+	return &pb.DeleteMyRatingResponse{
+		LaptopId:     req.GetLaptopId(),
+		RateCount:    rating.Count,
+		AverageScore: averageScore(rating),
+	}, nil
+}
+
+func (server *LaptopServer) GetLaptopRating(ctx context.Context, req *pb.GetLaptopRatingRequest) (*pb.GetLaptopRatingResponse, error) {
+	log.Printf("receive a get-laptop-rating request for laptop %s", req.GetId())
+
+	laptop, err := server.laptopStore.Find(req.GetId())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find laptop: %v", err)
+	}
+	if laptop == nil {
+		return nil, status.Errorf(codes.NotFound, "laptop %s does not exist", req.GetId())
+	}
+
+	summary, err := server.ratingSummary(laptop.Id)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot summarize rating: %v", err)
+	}
+
+	return &pb.GetLaptopRatingResponse{
+		LaptopId: laptop.Id,
+		Summary:  summary,
+	}, nil
+}
+
+// ratingSummary summarizes the scores given to a laptop.
+func (server *LaptopServer) ratingSummary(laptopId string) (*pb.RatingSummary, error) {
+	scores, err := server.ratingStore.Scores(laptopId)
+	if err != nil {
+		return nil, err
+	}
+
+	return summarizeRating(scores, server.config.MinRatingScore, server.config.MaxRatingScore), nil
+}
+
+// attachRatingSummary sets the rating summary of a found laptop if include is set,
+// and reports whether its average score is at least minAverage.
+func (server *LaptopServer) attachRatingSummary(laptop *pb.Laptop, include bool, minAverage float64) bool {
+	if !include && minAverage <= 0 {
+		return true
+	}
+
+	summary, err := server.ratingSummary(laptop.GetId())
+	if err != nil {
+		log.Printf("cannot summarize rating of laptop %s: %v", laptop.GetId(), err)
+		return false
+	}
+
+	if minAverage > 0 && (summary.Count == 0 || summary.Average < minAverage) {
+		return false
+	}
+
+	if include {
+		laptop.RatingSummary = summary
+	}
+
+	return true
+}
+
+// summarizeRating computes the statistics of the scores, whose histogram has a bucket for
+// each whole score between min and max, including the empty ones.
+func summarizeRating(scores []float64, min, max float64) *pb.RatingSummary {
+	summary := &pb.RatingSummary{}
+	for score := math.Ceil(min); score <= math.Floor(max); score++ {
+		summary.Histogram = append(summary.Histogram, &pb.RatingBucket{Score: score})
+	}
+
+	if len(scores) == 0 {
+		return summary
+	}
+
+	sorted := append([]float64{}, scores...)
+	sort.Float64s(sorted)
+
+	sum := 0.0
+	for _, score := range sorted {
+		sum += score
+
+		// a score rounded outside of the scale goes to the nearest bucket
+		if len(summary.Histogram) > 0 {
+			i := int(math.Round(score) - summary.Histogram[0].Score)
+			if i < 0 {
+				i = 0
+			}
+			if i >= len(summary.Histogram) {
+				i = len(summary.Histogram) - 1
+			}
+			summary.Histogram[i].Count++
+		}
+	}
+
+	n := len(sorted)
+	summary.Count = uint32(n)
+	summary.Average = sum / float64(n)
+
+	if n%2 == 1 {
+		summary.Median = sorted[n/2]
+	} else {
+		summary.Median = (sorted[n/2-1] + sorted[n/2]) / 2
+	}
+
+	variance := 0.0
+	for _, score := range sorted {
+		variance += (score - summary.Average) * (score - summary.Average)
+	}
+	summary.Stddev = math.Sqrt(variance / float64(n))
+
+	return summary
+}
+
+// ratingUsername returns the user that ratings are recorded for, who must be authenticated.
+func ratingUsername(ctx context.Context) (string, error) {
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok || claims.Username == "" {
+		return "", status.Errorf(codes.Unauthenticated, "rating a laptop requires an authenticated user")
+	}
+
+	return claims.Username, nil
+}
+
+func averageScore(rating *Rating) float64 {
+	if rating.Count == 0 {
+		return 0
+	}
+
+	return rating.Sum / float64(rating.Count)
+}
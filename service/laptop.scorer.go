@@ -0,0 +1,48 @@
+package service
+
+import (
+	"pc-book/pb"
+	"pc-book/units"
+)
+
+// Scorer computes the performance and value score of a laptop.
+// Implement it to plug a custom scoring formula into the laptop store.
+type Scorer interface {
+	Score(laptop *pb.Laptop) (performance, value float64)
+}
+
+type DefaultScorer struct{}
+
+func NewDefaultScorer() Scorer {
+	return &DefaultScorer{}
+}
+
+// Score implements Scorer.
+func (scorer *DefaultScorer) Score(laptop *pb.Laptop) (float64, float64) {
+	cpu := laptop.GetCpu()
+
+	performance := float64(cpu.GetCoresMunber())*10 +
+		float64(cpu.GetThreadsNumber())*5 +
+		cpu.GetMinFreq()*10 +
+		cpu.GetMaxFreq()*15
+
+	for _, gpu := range laptop.GetGpus() {
+		performance += units.ToGigabytes(gpu.GetMemory())*5 + gpu.GetMaxFreq()*10
+	}
+
+	performance += units.ToGigabytes(laptop.GetMemory()) * 2
+
+	for _, storage := range laptop.GetStorages() {
+		if storage.GetDriver() == pb.Storage_SSD {
+			performance += 20
+			break
+		}
+	}
+
+	value := 0.0
+	if laptop.GetPriceUsd() > 0 {
+		value = performance / laptop.GetPriceUsd()
+	}
+
+	return performance, value
+}
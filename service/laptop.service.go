@@ -1,32 +1,154 @@
 package service
 
 import (
-	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log"
+	"math"
+	"os"
+	"path/filepath"
 	"pc-book/pb"
+	"pc-book/units"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
-const MAX_IMAGE_SIZE = 1 << 20
+const (
+	DEFAULT_MAX_IMAGE_SIZE         = 1 << 20
+	DEFAULT_MAX_CONCURRENT_UPLOADS = 8
+	DOWNLOAD_CHUNK_SIZE            = 64 << 10
+	DEFAULT_MIN_RATING_SCORE       = 1
+	DEFAULT_MAX_RATING_SCORE       = 10
+)
 
 type LaptopServer struct {
 	pb.UnimplementedLaptopServiceServer
-	laptopStore LaptopStore
-	imageStore  ImageStore
-	ratingStore RatingStore
+	laptopStore    LaptopStore
+	imageStore     ImageStore
+	ratingStore    RatingStore
+	exchangeRates  ExchangeRateStore
+	imageValidator *ImageValidator
+	uploadSessions UploadSessionStore
+	uploads        chan struct{}
+	config         LaptopServerConfig
 }
 
-func NewLaptopServer(store LaptopStore, imgStore ImageStore, ratingStore RatingStore) *LaptopServer {
-	return &LaptopServer{laptopStore: store, imageStore: imgStore, ratingStore: ratingStore}
+// LaptopServerConfig holds the tunable limits of a LaptopServer.
+type LaptopServerConfig struct {
+	MaxImageWidth  int
+	MaxImageHeight int
+	// MaxImageSize is the upload size limit in bytes, unless RoleMaxImageSizes has one for the role of the caller.
+	MaxImageSize         int64
+	RoleMaxImageSizes    map[string]int64
+	MaxConcurrentUploads int
+	UploadFolder         string
+	UploadSessionTTL     time.Duration
+	// UploadSessions, if set, keeps the resumable uploads instead of a store in UploadFolder.
+	UploadSessions UploadSessionStore
+	// StripImageMetadata removes the EXIF, XMP and other metadata from uploaded images before they are saved.
+	StripImageMetadata bool
+	// Scanner, if set, must allow each uploaded image before it is saved.
+	// The images it quarantines are kept in QuarantineFolder.
+	Scanner          Scanner
+	QuarantineFolder string
+	// MinRatingScore and MaxRatingScore bound the scores of RateLaptop.
+	MinRatingScore float64
+	MaxRatingScore float64
+	// ImageURLSigner, if set, backs the GetImageURL RPC and lets the REST image endpoint serve signed URLs.
+	ImageURLSigner *ImageURLSigner
+	// ImageCollector, if set, backs the GetImageGCStats RPC.
+	ImageCollector *ImageCollector
 }
 
+func DefaultLaptopServerConfig() LaptopServerConfig {
+	return LaptopServerConfig{
+		MaxImageWidth:        DEFAULT_MAX_IMAGE_WIDTH,
+		MaxImageHeight:       DEFAULT_MAX_IMAGE_HEIGHT,
+		MaxImageSize:         DEFAULT_MAX_IMAGE_SIZE,
+		MaxConcurrentUploads: DEFAULT_MAX_CONCURRENT_UPLOADS,
+		UploadFolder:         DEFAULT_UPLOAD_FOLDER,
+		UploadSessionTTL:     DEFAULT_UPLOAD_SESSION_TTL,
+		StripImageMetadata:   true,
+		QuarantineFolder:     DEFAULT_QUARANTINE_FOLDER,
+		MinRatingScore:       DEFAULT_MIN_RATING_SCORE,
+		MaxRatingScore:       DEFAULT_MAX_RATING_SCORE,
+	}
+}
+
+func NewLaptopServer(store LaptopStore, imgStore ImageStore, ratingStore RatingStore, exchangeRates ExchangeRateStore) *LaptopServer {
+	return NewLaptopServerWithConfig(store, imgStore, ratingStore, exchangeRates, DefaultLaptopServerConfig())
+}
+
+func NewLaptopServerWithConfig(
+	store LaptopStore,
+	imgStore ImageStore,
+	ratingStore RatingStore,
+	exchangeRates ExchangeRateStore,
+	config LaptopServerConfig,
+) *LaptopServer {
+	// limits that would block or reject every upload fall back to their defaults
+	if config.MaxConcurrentUploads < 1 {
+		config.MaxConcurrentUploads = DEFAULT_MAX_CONCURRENT_UPLOADS
+	}
+	if config.MaxImageSize < 1 {
+		config.MaxImageSize = DEFAULT_MAX_IMAGE_SIZE
+	}
+	if config.MaxImageWidth < 1 {
+		config.MaxImageWidth = DEFAULT_MAX_IMAGE_WIDTH
+	}
+	if config.MaxImageHeight < 1 {
+		config.MaxImageHeight = DEFAULT_MAX_IMAGE_HEIGHT
+	}
+	// so does an unset or reversed score range
+	unsetScores := config.MinRatingScore == 0 && config.MaxRatingScore == 0
+	if unsetScores || !(config.MinRatingScore <= config.MaxRatingScore) {
+		config.MinRatingScore = DEFAULT_MIN_RATING_SCORE
+		config.MaxRatingScore = DEFAULT_MAX_RATING_SCORE
+	}
+
+	roleSizes := make(map[string]int64)
+	for role, size := range config.RoleMaxImageSizes {
+		if size >= 1 {
+			roleSizes[role] = size
+		}
+	}
+	config.RoleMaxImageSizes = roleSizes
+
+	uploadSessions := config.UploadSessions
+	if uploadSessions == nil {
+		uploadSessions = NewDiskUploadSessionStore(config.UploadFolder, config.UploadSessionTTL)
+	}
+
+	return &LaptopServer{
+		laptopStore:    store,
+		imageStore:     imgStore,
+		ratingStore:    ratingStore,
+		exchangeRates:  exchangeRates,
+		imageValidator: NewImageValidator(config.MaxImageWidth, config.MaxImageHeight),
+		uploadSessions: uploadSessions,
+		uploads:        make(chan struct{}, config.MaxConcurrentUploads),
+		config:         config,
+	}
+}
+
+// RateLaptop records the score of each request. A request that cannot be rated is answered
+// with its error status, and the stream goes on with the next one.
 func (server *LaptopServer) RateLaptop(stream pb.LaptopService_RateLaptopServer) error {
+	username, err := ratingUsername(stream.Context())
+	if err != nil {
+		return err
+	}
+
 	for {
 		err := contexError(stream.Context())
 		if err != nil {
@@ -39,69 +161,89 @@ func (server *LaptopServer) RateLaptop(stream pb.LaptopService_RateLaptopServer)
 			break
 		}
 		if err != nil {
-			log.Fatalf("cannot receive stream request: %v", err)
-			return status.Errorf(codes.Internal, "annot receive stream request")
+			return status.Errorf(codes.Unknown, "cannot receive stream request: %v", err)
 		}
 
 		log.Printf("received a rate-laptop request id %s, score %.2f", req.GetLaptopId(), req.GetScore())
 
-		laptop, err := server.laptopStore.Find(req.GetLaptopId())
-		if err != nil {
-			log.Fatalf("cannot find laptop: %v", err)
-			return status.Errorf(codes.Internal, "cannot find laptop")
-		}
-		if laptop == nil {
-			log.Fatalf("laptop does not exist: %v", err)
-			return status.Errorf(codes.NotFound, "laptop does not exist")
+		res := &pb.RateLaptopResponse{
+			LaptopId: req.GetLaptopId(),
 		}
 
-		rating, err := server.ratingStore.Add(laptop.Id, req.GetScore())
+		rating, err := server.rateLaptop(req.GetLaptopId(), username, req.GetScore())
 		if err != nil {
-			log.Fatalf("cannot add rating laptop: %v", err)
-			return status.Errorf(codes.Internal, "cannot add rating laptop")
+			log.Print("cannot rate laptop: ", err)
+		} else {
+			res.RateCount = rating.Count
+			res.AverageScore = averageScore(rating)
 		}
+		res.Status = status.Convert(err).Proto()
 
-		err = stream.Send(&pb.RateLaptopResponse{
-			LaptopId:     laptop.GetId(),
-			RateCount:    rating.Count,
-			AverageScore: rating.Sum / float64(rating.Count),
-		})
+		err = stream.Send(res)
 		if err != nil {
-			log.Fatalf("cannot send rating response: %v", err)
-			return status.Errorf(codes.Internal, "cannot send rating response")
+			return status.Errorf(codes.Unknown, "cannot send rating response: %v", err)
 		}
 	}
 
 	return nil
 }
 
+func (server *LaptopServer) rateLaptop(laptopId, username string, score float64) (*Rating, error) {
+	if math.IsNaN(score) || score < server.config.MinRatingScore || score > server.config.MaxRatingScore {
+		return nil, status.Errorf(codes.InvalidArgument, "score must be between %g and %g", server.config.MinRatingScore, server.config.MaxRatingScore)
+	}
+
+	laptop, err := server.laptopStore.Find(laptopId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find laptop: %v", err)
+	}
+	if laptop == nil {
+		return nil, status.Errorf(codes.NotFound, "laptop %s does not exist", laptopId)
+	}
+
+	rating, err := server.ratingStore.Add(laptop.Id, username, score)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot add rating: %v", err)
+	}
+
+	return rating, nil
+}
+
 func (server *LaptopServer) UploadImage(stream pb.LaptopService_UploadImageServer) error {
 	req, err := stream.Recv()
 	if err != nil {
-		log.Fatalf("cannot receive image info: %v", err)
-		return status.Errorf(codes.Internal, "cannot receive image info")
+		return status.Errorf(codes.Unknown, "cannot receive image info: %v", err)
 	}
 
-	laptopId, imageType := req.GetInfo().LaptopId, req.GetInfo().GetImageType()
+	laptopId, imageType, checksum := req.GetInfo().LaptopId, req.GetInfo().GetImageType(), req.GetInfo().GetChecksum()
 
 	log.Printf("receive and upload-image request for laptop %s with image type %s", laptopId, imageType)
 
 	laptop, err := server.laptopStore.Find(laptopId)
 	if err != nil {
-		log.Fatalf("cannot find laptop: %v", err)
-		return status.Errorf(codes.Internal, "cannot find laptop")
+		return status.Errorf(codes.Internal, "cannot find laptop: %v", err)
 	}
 	if laptop == nil {
-		log.Fatalf("laptop does not exist: %v", err)
 		return status.Errorf(codes.NotFound, "laptop does not exist")
 	}
 
-	imageData := bytes.Buffer{}
-	imageSize := 0
+	release, err := server.acquireUpload(stream.Context())
+	if err != nil {
+		return err
+	}
+	defer release()
 
-	for {
-		log.Print("waiting to receive more data...")
+	maxImageSize := server.maxImageSize(stream.Context())
 
+	imageData, err := createTempFile(server.config.UploadFolder, "stream.*.tmp")
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot create image file: %v", err)
+	}
+	defer imageData.Close()
+
+	imageSize := int64(0)
+
+	for {
 		err := contexError(stream.Context())
 		if err != nil {
 			return err
@@ -113,70 +255,458 @@ func (server *LaptopServer) UploadImage(stream pb.LaptopService_UploadImageServe
 			break
 		}
 		if err != nil {
-			log.Fatalf("cannot receive chunk data: %v", err)
-			return status.Errorf(codes.Unknown, "cannot receive chunk data")
+			return status.Errorf(codes.Unknown, "cannot receive chunk data: %v", err)
 		}
 
 		chunk := req.GetChunkData()
-		size := len(chunk)
 
-		imageSize += size
-		if imageSize > MAX_IMAGE_SIZE {
-			log.Fatal("image data too large")
-			return status.Errorf(codes.InvalidArgument, "image data too large")
+		imageSize += int64(len(chunk))
+		if imageSize > maxImageSize {
+			return status.Errorf(codes.InvalidArgument, "image data too large: limit is %d bytes", maxImageSize)
 		}
 
 		_, err = imageData.Write(chunk)
 		if err != nil {
-			log.Fatalf("cannot write chunk data: %v", err)
-			return status.Errorf(codes.Internal, "cannot write chunk data")
+			return status.Errorf(codes.Internal, "cannot write chunk data: %v", err)
 		}
 	}
 
-	imageId, err := server.imageStore.Save(laptopId, imageType, imageData)
+	_, err = imageData.Seek(0, io.SeekStart)
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot rewind image file: %v", err)
+	}
+
+	image, err := server.saveImage(stream.Context(), laptopId, imageType, checksum, imageData.File)
 	if err != nil {
-		log.Fatalf("cannot save image to the store: %v", err)
-		return status.Errorf(codes.Internal, "cannot save image to the store")
+		return err
 	}
 
 	err = stream.SendAndClose(&pb.UploadImageResponse{
-		Id:   imageId,
+		Id:   image.Id,
 		Size: uint32(imageSize),
 	})
 	if err != nil {
-		log.Fatalf("cannot send response: %v", err)
-		return status.Errorf(codes.Unknown, "cannot send response")
+		return status.Errorf(codes.Unknown, "cannot send response: %v", err)
+	}
+
+	log.Printf("saved image with id %s and size %d", image.Id, imageSize)
+
+	return nil
+}
+
+// saveImage validates, strips and scans the content of a received image and saves it to the image store.
+// A non-empty checksum is the SHA-256 the client declared for the image.
+func (server *LaptopServer) saveImage(ctx context.Context, laptopId, imageType, checksum string, imageData *os.File) (*ImageInfo, error) {
+	content, err := server.imageValidator.Validate(imageType, imageData)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid image: %v", err)
+	}
+
+	_, err = imageData.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot rewind image: %v", err)
+	}
+
+	if server.config.StripImageMetadata {
+		stripped, err := server.stripImageMetadata(content.MimeType, checksum, imageData)
+		if err != nil {
+			return nil, err
+		}
+		defer stripped.Close()
+
+		// the declared checksum is of the image as sent, so it is checked before stripping
+		imageData, checksum = stripped.File, ""
+	}
+
+	err = server.scanImage(ctx, laptopId, imageType, imageData)
+	if err != nil {
+		return nil, err
+	}
+
+	imageId, err := server.imageStore.SaveFile(&ImageInfo{
+		LaptopId:         laptopId,
+		Type:             imageType,
+		MimeType:         content.MimeType,
+		Width:            uint32(content.Width),
+		Height:           uint32(content.Height),
+		Checksum:         checksum,
+		MetadataStripped: server.config.StripImageMetadata,
+	}, imageData.Name())
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, ErrImageChecksumMismatch) {
+			code = codes.DataLoss
+		}
+
+		return nil, status.Errorf(code, "cannot save image to the store: %v", err)
+	}
+
+	image, err := server.imageStore.Find(imageId)
+	if err != nil || image == nil {
+		return nil, status.Errorf(codes.Internal, "cannot find saved image: %v", err)
+	}
+
+	return image, nil
+}
+
+// stripImageMetadata copies an image without its metadata to a temp file, checking the image
+// against its declared checksum on the way.
+func (server *LaptopServer) stripImageMetadata(mimeType, checksum string, imageData io.Reader) (*tempFile, error) {
+	stripped, err := createTempFile(server.config.UploadFolder, "strip.*.tmp")
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot create image file: %v", err)
+	}
+
+	hash := sha256.New()
+	removed, err := StripImageMetadata(mimeType, stripped, io.TeeReader(imageData, hash))
+	if err == nil {
+		_, err = stripped.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		stripped.Close()
+		return nil, status.Errorf(codes.InvalidArgument, "cannot strip image metadata: %v", err)
+	}
+
+	// the tee only sees the bytes the stripper read, which may end before the image does
+	_, err = io.Copy(hash, imageData)
+	if err != nil {
+		stripped.Close()
+		return nil, status.Errorf(codes.Internal, "cannot read image: %v", err)
+	}
+
+	if checksum != "" && !strings.EqualFold(checksum, hex.EncodeToString(hash.Sum(nil))) {
+		stripped.Close()
+		return nil, status.Errorf(codes.DataLoss, "cannot save image to the store: %v", ErrImageChecksumMismatch)
+	}
+
+	if removed > 0 {
+		log.Printf("stripped %d metadata segments from %s image", removed, mimeType)
+	}
+
+	return stripped, nil
+}
+
+// scanImage runs the image through the configured Scanner, and fails unless it is allowed.
+// Images the scanner quarantines are kept in the quarantine folder.
+func (server *LaptopServer) scanImage(ctx context.Context, laptopId, imageType string, imageData *os.File) error {
+	scanner := server.config.Scanner
+	if scanner == nil {
+		return nil
+	}
+
+	result, err := scanner.Scan(ctx, imageData.Name())
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot scan image: %v", err)
+	}
+
+	_, err = imageData.Seek(0, io.SeekStart)
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot rewind image: %v", err)
+	}
+
+	switch result.Verdict {
+	case ScanAllow:
+		return nil
+	case ScanQuarantine:
+		quarantineId, err := server.quarantineImage(laptopId, imageType, imageData, result)
+		if err != nil {
+			return status.Errorf(codes.Internal, "cannot quarantine image: %v", err)
+		}
+
+		log.Printf("quarantined image %s for laptop %s: %s", quarantineId, laptopId, result.Reason)
+	default:
+		log.Printf("rejected image for laptop %s: %s", laptopId, result.Reason)
+	}
+
+	return status.Errorf(codes.InvalidArgument, "image was rejected by the scanner: %s", result.Reason)
+}
+
+// quarantinedImage is the sidecar of an image in the quarantine folder.
+type quarantinedImage struct {
+	LaptopId      string    `json:"laptop_id"`
+	Type          string    `json:"type"`
+	Verdict       string    `json:"verdict"`
+	Reason        string    `json:"reason"`
+	QuarantinedAt time.Time `json:"quarantined_at"`
+}
+
+func (server *LaptopServer) quarantineImage(laptopId, imageType string, imageData io.Reader, result *ScanResult) (string, error) {
+	quarantineId, err := uuid.NewRandom()
+	if err != nil {
+		return "", fmt.Errorf("cannot generate quarantine id: %w", err)
+	}
+
+	folder := server.config.QuarantineFolder
+	err = os.MkdirAll(folder, 0755)
+	if err != nil {
+		return "", fmt.Errorf("cannot create quarantine folder: %w", err)
+	}
+
+	file, err := os.OpenFile(filepath.Join(folder, quarantineId.String()+imageType), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", fmt.Errorf("cannot create quarantine file: %w", err)
+	}
+
+	_, err = io.Copy(file, imageData)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("cannot write quarantine file: %w", err)
+	}
+
+	data, err := json.Marshal(&quarantinedImage{
+		LaptopId:      laptopId,
+		Type:          imageType,
+		Verdict:       result.Verdict.String(),
+		Reason:        result.Reason,
+		QuarantinedAt: time.Now(),
+	})
+	if err == nil {
+		err = writeFileAtomic(filepath.Join(folder, quarantineId.String()+".json"), data)
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot write quarantine metadata: %w", err)
 	}
 
-	log.Printf("saved image with id %s and size %d", imageId, imageSize)
+	return quarantineId.String(), nil
+}
+
+func (server *LaptopServer) DownloadImage(req *pb.DownloadImageRequest, stream pb.LaptopService_DownloadImageServer) error {
+	imageId, variant := req.GetImageId(), req.GetVariant()
+
+	log.Printf("receive a download-image request for image %s, variant %q", imageId, variant)
+
+	image, file, err := server.imageStore.Open(imageId, variant)
+	if errors.Is(err, ErrImageVariantNotFound) {
+		return status.Errorf(codes.NotFound, "%v", err)
+	}
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot open image: %v", err)
+	}
+	if file == nil {
+		return status.Errorf(codes.NotFound, "image %s does not exist", imageId)
+	}
+	defer file.Close()
+
+	err = stream.Send(&pb.DownloadImageResponse{
+		Data: &pb.DownloadImageResponse_Info{
+			Info: toImageInfoMessage(image),
+		},
+	})
+	if err != nil {
+		return status.Errorf(codes.Unknown, "cannot send image info: %v", err)
+	}
+
+	buffer := make([]byte, DOWNLOAD_CHUNK_SIZE)
+	for {
+		err := contexError(stream.Context())
+		if err != nil {
+			return err
+		}
+
+		n, err := file.Read(buffer)
+		if n > 0 {
+			sendErr := stream.Send(&pb.DownloadImageResponse{
+				Data: &pb.DownloadImageResponse_ChunkData{
+					ChunkData: buffer[:n],
+				},
+			})
+			if sendErr != nil {
+				return status.Errorf(codes.Unknown, "cannot send chunk data: %v", sendErr)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return status.Errorf(codes.Internal, "cannot read image file: %v", err)
+		}
+	}
+
+	log.Printf("sent image with id %s and size %d", image.Id, image.Size)
 
 	return nil
 }
 
+func (server *LaptopServer) GetLaptop(ctx context.Context, req *pb.GetLaptopRequest) (*pb.GetLaptopResponse, error) {
+	log.Printf("receive a get laptop request with id: %s", req.GetId())
+
+	err := validateReadMask(req.GetReadMask())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	laptop, err := server.laptopStore.Find(req.GetId())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find laptop: %v", err)
+	}
+	if laptop == nil {
+		return nil, status.Errorf(codes.NotFound, "laptop %s does not exist", req.GetId())
+	}
+
+	currencyCode := strings.ToUpper(req.GetCurrencyCode())
+	if currencyCode == "" {
+		currencyCode = DEFAULT_CURRENCY
+	}
+
+	price, err := server.convertPrice(laptopPrice(laptop), currencyCode)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid currency: %v", err)
+	}
+
+	laptop.Price = laptopPrice(laptop)
+	laptop.ConvertedPrice = price
+	applyReadMask(laptop, req.GetReadMask())
+
+	return &pb.GetLaptopResponse{
+		Laptop: laptop,
+	}, nil
+}
+
 func (server *LaptopServer) SearchLaptop(req *pb.SearchLaptopRequest, stream pb.LaptopService_SearchLaptopServer) error {
 	filter := req.GetFilter()
 
 	log.Printf("receive search-filter request with filter: %v", filter)
 
-	err := server.laptopStore.Search(stream.Context(), filter, func(laptop *pb.Laptop) error {
+	err := validateFilter(filter)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid filter: %v", err)
+	}
+
+	err = validateReadMask(req.GetReadMask())
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	currencyCode, maxPrice, err := server.searchCurrency(req)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid currency: %v", err)
+	}
+
+	prepare := func(laptop *pb.Laptop) error {
+		if req.GetIncludePrimaryImage() {
+			err := server.attachPrimaryImage(laptop)
+			if err != nil {
+				return err
+			}
+		}
+
+		applyReadMask(laptop, req.GetReadMask())
+
+		return nil
+	}
+
+	send := func(laptop *pb.Laptop) error {
+		err := prepare(laptop)
+		if err != nil {
+			return err
+		}
+
 		res := &pb.SearchLaptopResponse{Laptop: laptop}
 
-		err := stream.Send(res)
+		err = stream.Send(res)
 		if err != nil {
 			return err
 		}
 
 		log.Printf("send laptop with id: %s", laptop.GetId())
 
+		return nil
+	}
+	flush := func() error {
+		return nil
+	}
+
+	if req.GetBatchSize() > 1 {
+		batcher := newSearchBatcher(stream, int(req.GetBatchSize()), SEARCH_BATCH_LATENCY)
+		defer batcher.stop()
+
+		send = func(laptop *pb.Laptop) error {
+			err := prepare(laptop)
+			if err != nil {
+				return err
+			}
+
+			return batcher.add(laptop)
+		}
+		flush = batcher.flush
+	}
+
+	if req.GetSortBy() == pb.SearchLaptopRequest_UNSORTED {
+		err = server.laptopStore.Search(stream.Context(), filter, func(laptop *pb.Laptop) error {
+			if !server.attachConvertedPrice(laptop, currencyCode, maxPrice) {
+				return nil
+			}
+			if !server.attachRatingSummary(laptop, req.GetIncludeRatingSummary(), filter.GetMinAverageRating()) {
+				return nil
+			}
+
+			return send(laptop)
+		})
+		if err == nil {
+			err = flush()
+		}
+		if err != nil {
+			return status.Errorf(codes.Internal, "unexpected error: %v", err)
+		}
+
+		return nil
+	}
+
+	laptops := []*pb.Laptop{}
+	err = server.laptopStore.Search(stream.Context(), filter, func(laptop *pb.Laptop) error {
+		if server.attachConvertedPrice(laptop, currencyCode, maxPrice) &&
+			server.attachRatingSummary(laptop, req.GetIncludeRatingSummary(), filter.GetMinAverageRating()) {
+			laptops = append(laptops, laptop)
+		}
+
 		return nil
 	})
 	if err != nil {
 		return status.Errorf(codes.Internal, "unexpected error: %v", err)
 	}
 
+	sortLaptops(laptops, req.GetSortBy(), req.GetDescending())
+
+	for _, laptop := range laptops {
+		err := send(laptop)
+		if err != nil {
+			return status.Errorf(codes.Internal, "unexpected error: %v", err)
+		}
+	}
+
+	err = flush()
+	if err != nil {
+		return status.Errorf(codes.Internal, "unexpected error: %v", err)
+	}
+
 	return nil
 }
 
+func sortLaptops(laptops []*pb.Laptop, sortBy pb.SearchLaptopRequest_SortBy, descending bool) {
+	key := func(laptop *pb.Laptop) float64 {
+		switch sortBy {
+		case pb.SearchLaptopRequest_PERFORMANCE_SCORE:
+			return laptop.GetPerformanceScore()
+		case pb.SearchLaptopRequest_VALUE_SCORE:
+			return laptop.GetValueScore()
+		case pb.SearchLaptopRequest_PRICE:
+			return units.MoneyToFloat(laptop.GetConvertedPrice())
+		default:
+			return 0
+		}
+	}
+
+	sort.SliceStable(laptops, func(i, j int) bool {
+		if descending {
+			return key(laptops[i]) > key(laptops[j])
+		}
+		return key(laptops[i]) < key(laptops[j])
+	})
+}
+
 func (server *LaptopServer) CreateLaptop(ctx context.Context, req *pb.CreateLaptopRequest) (*pb.CreateLaptopResponse, error) {
 	laptop := req.GetLaptop()
 
@@ -196,7 +726,17 @@ func (server *LaptopServer) CreateLaptop(ctx context.Context, req *pb.CreateLapt
 		laptop.Id = id.String()
 	}
 
-	err := contexError(ctx)
+	err := validateLaptop(laptop)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid laptop: %v", err)
+	}
+
+	err = server.normalizePrice(laptop)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid price: %v", err)
+	}
+
+	err = contexError(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -218,6 +758,48 @@ func (server *LaptopServer) CreateLaptop(ctx context.Context, req *pb.CreateLapt
 	}, nil
 }
 
+// DeleteLaptop removes a laptop together with its images.
+// Image contents shared with other laptops are kept.
+func (server *LaptopServer) DeleteLaptop(ctx context.Context, req *pb.DeleteLaptopRequest) (*pb.DeleteLaptopResponse, error) {
+	log.Printf("receive a delete-laptop request with id: %s", req.GetId())
+
+	laptop, err := server.laptopStore.Find(req.GetId())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find laptop: %v", err)
+	}
+	if laptop == nil {
+		return nil, status.Errorf(codes.NotFound, "laptop %s does not exist", req.GetId())
+	}
+
+	err = server.laptopStore.Delete(laptop.Id)
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, ErrNotFound) {
+			code = codes.NotFound
+		}
+
+		return nil, status.Errorf(code, "cannot delete laptop: %v", err)
+	}
+
+	if server.config.ImageCollector != nil {
+		server.config.ImageCollector.LaptopDeleted(laptop.Id)
+	}
+
+	deleted := 0
+	if server.imageStore != nil {
+		deleted, err = server.imageStore.DeleteLaptop(laptop.Id)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "cannot delete laptop images: %v", err)
+		}
+	}
+
+	log.Printf("deleted laptop %s with %d images", laptop.Id, deleted)
+
+	return &pb.DeleteLaptopResponse{
+		DeletedImages: uint32(deleted),
+	}, nil
+}
+
 func contexError(ctx context.Context) error {
 	switch ctx.Err() {
 	case context.Canceled:
@@ -4,66 +4,190 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"log"
 	"pc-book/pb"
+	"runtime"
 	"sync"
 
 	"github.com/jinzhu/copier"
 )
 
-var ErrAlreadyExist = errors.New("record already exist")
+var (
+	ErrAlreadyExist = errors.New("record already exist")
+	ErrNotFound     = errors.New("record not found")
+)
 
 type LaptopStore interface {
 	Save(laptop *pb.Laptop) error
 	Find(id string) (*pb.Laptop, error)
+	Delete(id string) error
 	Search(ctx context.Context, filter *pb.FilterMessage, found func(laptop *pb.Laptop) error) error
+	Sample(limit int) ([]*pb.Laptop, error)
+	// Suggest returns the indexed terms of a field that complete prefix, kept current as laptops are saved and deleted.
+	Suggest(field pb.SuggestRequest_Field, prefix string, limit int) ([]*pb.Suggestion, error)
 }
 
-type InMemoryLaptopStore struct {
+const DEFAULT_SHARD_COUNT = 16
+
+var errContextCancelled = errors.New("context is cancelled")
+
+type laptopShard struct {
 	mutex sync.RWMutex
 	data  map[string]*pb.Laptop
 }
 
+// shardResult holds the qualified laptops of one shard, closing done once the shard is scanned.
+type shardResult struct {
+	laptops []*pb.Laptop
+	err     error
+	done    chan struct{}
+}
+
+type InMemoryLaptopStore struct {
+	shards      []*laptopShard
+	workers     int
+	scorer      Scorer
+	suggestions *InMemorySuggestionIndex
+}
+
 func NewInMemoryLaptopStore() *InMemoryLaptopStore {
+	return NewInMemoryLaptopStoreWithScorer(NewDefaultScorer())
+}
+
+func NewInMemoryLaptopStoreWithScorer(scorer Scorer) *InMemoryLaptopStore {
+	return NewShardedInMemoryLaptopStore(DEFAULT_SHARD_COUNT, runtime.NumCPU(), scorer)
+}
+
+// NewShardedInMemoryLaptopStore creates a store that partitions laptops into shardCount shards,
+// each with its own lock, and scans at most workers shards in parallel when searching.
+func NewShardedInMemoryLaptopStore(shardCount, workers int, scorer Scorer) *InMemoryLaptopStore {
+	if shardCount < 1 {
+		shardCount = 1
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	shards := make([]*laptopShard, shardCount)
+	for i := range shards {
+		shards[i] = &laptopShard{
+			data: make(map[string]*pb.Laptop),
+		}
+	}
+
 	return &InMemoryLaptopStore{
-		data: make(map[string]*pb.Laptop),
+		shards:      shards,
+		workers:     workers,
+		scorer:      scorer,
+		suggestions: NewInMemorySuggestionIndex(),
 	}
 }
 
+func (store *InMemoryLaptopStore) shard(id string) *laptopShard {
+	hash := fnv.New32a()
+	hash.Write([]byte(id))
+
+	return store.shards[hash.Sum32()%uint32(len(store.shards))]
+}
+
 // Search implements LaptopStore.
+// Shards are scanned by a bounded pool of workers, and the results are passed to found
+// from the calling goroutine in shard order.
 func (store *InMemoryLaptopStore) Search(ctx context.Context, filter *pb.FilterMessage, found func(laptop *pb.Laptop) error) error {
-	store.mutex.RLock()
-	defer store.mutex.RUnlock()
+	wg := sync.WaitGroup{}
+	defer wg.Wait()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]*shardResult, len(store.shards))
+	for i := range results {
+		results[i] = &shardResult{done: make(chan struct{})}
+	}
+
+	jobs := make(chan int)
+
+	for w := 0; w < store.workers && w < len(store.shards); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range jobs {
+				result := results[i]
+				result.laptops, result.err = store.shards[i].search(ctx, filter)
+				close(result.done)
+			}
+		}()
+	}
 
-	for _, laptop := range store.data {
-		if ctx.Err() == context.Canceled || ctx.Err() == context.DeadlineExceeded {
+	go func() {
+		defer close(jobs)
+
+		for i := range store.shards {
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for _, result := range results {
+		select {
+		case <-result.done:
+		case <-ctx.Done():
 			log.Print("context is cancelled")
 
-			return errors.New("context is cancelled")
+			return errContextCancelled
+		}
+
+		if result.err != nil {
+			return result.err
+		}
+
+		for _, laptop := range result.laptops {
+			err := found(laptop)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (shard *laptopShard) search(ctx context.Context, filter *pb.FilterMessage) ([]*pb.Laptop, error) {
+	shard.mutex.RLock()
+	defer shard.mutex.RUnlock()
+
+	laptops := []*pb.Laptop{}
+	for _, laptop := range shard.data {
+		if ctx.Err() != nil {
+			return nil, errContextCancelled
 		}
 
 		if isQualified(filter, laptop) {
 			other := &pb.Laptop{}
 			err := copier.Copy(other, laptop)
 			if err != nil {
-				return fmt.Errorf("cannot copy laptop data: %w", err)
+				return nil, fmt.Errorf("cannot copy laptop data: %w", err)
 			}
 
-			err = found(other)
-			if err != nil {
-				return err
-			}
+			laptops = append(laptops, other)
 		}
 	}
 
-	return nil
+	return laptops, nil
 }
 
 func (store *InMemoryLaptopStore) Save(laptop *pb.Laptop) error {
-	store.mutex.Lock()
-	defer store.mutex.Unlock()
+	shard := store.shard(laptop.Id)
 
-	if store.data[laptop.Id] != nil {
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	if shard.data[laptop.Id] != nil {
 		return ErrAlreadyExist
 	}
 
@@ -73,16 +197,21 @@ func (store *InMemoryLaptopStore) Save(laptop *pb.Laptop) error {
 		return fmt.Errorf("cannot copy laptop data: %w", err)
 	}
 
-	store.data[other.Id] = other
+	other.PerformanceScore, other.ValueScore = store.scorer.Score(other)
+
+	shard.data[other.Id] = other
+	store.suggestions.Add(other)
 
 	return nil
 }
 
 func (store *InMemoryLaptopStore) Find(id string) (*pb.Laptop, error) {
-	store.mutex.RLock()
-	defer store.mutex.RUnlock()
+	shard := store.shard(id)
 
-	laptop := store.data[id]
+	shard.mutex.RLock()
+	defer shard.mutex.RUnlock()
+
+	laptop := shard.data[id]
 	if laptop == nil {
 		return nil, nil
 	}
@@ -96,41 +225,63 @@ func (store *InMemoryLaptopStore) Find(id string) (*pb.Laptop, error) {
 	return other, nil
 }
 
-func isQualified(filter *pb.FilterMessage, laptop *pb.Laptop) bool {
-	if laptop.GetPriceUsd() > filter.GetMaxPriceUsd() {
-		return false
-	}
+// Delete implements LaptopStore.
+func (store *InMemoryLaptopStore) Delete(id string) error {
+	shard := store.shard(id)
+
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
 
-	if laptop.GetCpu().GetCoresMunber() < filter.GetCpuCores() {
-		return false
+	laptop := shard.data[id]
+	if laptop == nil {
+		return ErrNotFound
 	}
 
-	if laptop.GetCpu().GetMinFreq() < filter.GetMixCpuGhz() {
-		return false
+	delete(shard.data, id)
+	store.suggestions.Remove(laptop)
+
+	return nil
+}
+
+// Sample implements LaptopStore.
+// It returns up to limit laptops in no particular order.
+func (store *InMemoryLaptopStore) Sample(limit int) ([]*pb.Laptop, error) {
+	laptops := []*pb.Laptop{}
+
+	for _, shard := range store.shards {
+		shard.mutex.RLock()
+		for _, laptop := range shard.data {
+			if len(laptops) >= limit {
+				break
+			}
+
+			other := &pb.Laptop{}
+			err := copier.Copy(other, laptop)
+			if err != nil {
+				shard.mutex.RUnlock()
+				return nil, fmt.Errorf("cannot copy laptop data: %w", err)
+			}
+
+			laptops = append(laptops, other)
+		}
+		shard.mutex.RUnlock()
 	}
 
-	if toBit(laptop.GetMemory()) < toBit(filter.GetMinRam()) {
-		return false
+	return laptops, nil
+}
+
+func isQualified(filter *pb.FilterMessage, laptop *pb.Laptop) bool {
+	for _, clause := range filterClauses {
+		result := clause(filter, laptop)
+		if result != nil && !result.Passed {
+			return false
+		}
 	}
 
 	return true
 }
 
-func toBit(memory *pb.Memory) uint64 {
-	switch memory.GetUnit() {
-	case pb.Memory_BIT:
-		return memory.GetValue()
-	case pb.Memory_BYTE:
-		return memory.GetValue() << 3
-	case pb.Memory_KB:
-		return memory.GetValue() << 13
-	case pb.Memory_MB:
-		return memory.GetValue() << 23
-	case pb.Memory_GB:
-		return memory.GetValue() << 33
-	case pb.Memory_TB:
-		return memory.GetValue() << 43
-	default:
-		return 0
-	}
+// Suggest implements LaptopStore.
+func (store *InMemoryLaptopStore) Suggest(field pb.SuggestRequest_Field, prefix string, limit int) ([]*pb.Suggestion, error) {
+	return store.suggestions.Suggest(field, prefix, limit), nil
 }
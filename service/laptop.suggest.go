@@ -0,0 +1,40 @@
+package service
+
+import (
+	"context"
+	"log"
+	"pc-book/pb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	DEFAULT_SUGGEST_LIMIT = 10
+	MAX_SUGGEST_LIMIT     = 50
+)
+
+func (server *LaptopServer) Suggest(ctx context.Context, req *pb.SuggestRequest) (*pb.SuggestResponse, error) {
+	log.Printf("receive a suggest request for %v with prefix %q", req.GetField(), req.GetPrefix())
+
+	if req.GetField() == pb.SuggestRequest_UNKNOWN {
+		return nil, status.Errorf(codes.InvalidArgument, "suggest field is not provided")
+	}
+
+	limit := int(req.GetLimit())
+	if limit == 0 {
+		limit = DEFAULT_SUGGEST_LIMIT
+	}
+	if limit > MAX_SUGGEST_LIMIT {
+		limit = MAX_SUGGEST_LIMIT
+	}
+
+	suggestions, err := server.laptopStore.Suggest(req.GetField(), req.GetPrefix(), limit)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot suggest: %v", err)
+	}
+
+	return &pb.SuggestResponse{
+		Suggestions: suggestions,
+	}, nil
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"pc-book/pb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (server *LaptopServer) StartUpload(ctx context.Context, req *pb.StartUploadRequest) (*pb.StartUploadResponse, error) {
+	laptopId, imageType := req.GetInfo().GetLaptopId(), req.GetInfo().GetImageType()
+
+	log.Printf("receive a start-upload request for laptop %s with image type %s, size %d", laptopId, imageType, req.GetSize())
+
+	maxImageSize := server.maxImageSize(ctx)
+	if req.GetSize() == 0 || req.GetSize() > uint64(maxImageSize) {
+		return nil, status.Errorf(codes.InvalidArgument, "image size must be between 1 and %d bytes", maxImageSize)
+	}
+
+	laptop, err := server.laptopStore.Find(laptopId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find laptop: %v", err)
+	}
+	if laptop == nil {
+		return nil, status.Errorf(codes.NotFound, "laptop %s does not exist", laptopId)
+	}
+
+	session, err := server.uploadSessions.Start(laptopId, imageType, req.GetSize(), req.GetChecksum())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot start upload: %v", err)
+	}
+
+	return &pb.StartUploadResponse{
+		UploadId:  session.Id,
+		ExpiresAt: timestamppb.New(session.ExpiresAt),
+	}, nil
+}
+
+func (server *LaptopServer) UploadChunk(ctx context.Context, req *pb.UploadChunkRequest) (*pb.UploadChunkResponse, error) {
+	release, err := server.acquireUpload(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	session, err := server.uploadSessions.Write(req.GetUploadId(), req.GetOffset(), req.GetChunkData())
+	if err != nil {
+		return nil, uploadSessionError("cannot write chunk", err)
+	}
+
+	return &pb.UploadChunkResponse{
+		UploadId:      session.Id,
+		CommittedSize: session.Committed,
+	}, nil
+}
+
+func (server *LaptopServer) QueryUpload(ctx context.Context, req *pb.QueryUploadRequest) (*pb.QueryUploadResponse, error) {
+	session, err := server.uploadSessions.Find(req.GetUploadId())
+	if err != nil {
+		return nil, uploadSessionError("cannot query upload", err)
+	}
+
+	return &pb.QueryUploadResponse{
+		UploadId:      session.Id,
+		Size:          session.Size,
+		CommittedSize: session.Committed,
+		ExpiresAt:     timestamppb.New(session.ExpiresAt),
+	}, nil
+}
+
+func (server *LaptopServer) FinishUpload(ctx context.Context, req *pb.FinishUploadRequest) (*pb.FinishUploadResponse, error) {
+	log.Printf("receive a finish-upload request for upload %s", req.GetUploadId())
+
+	release, err := server.acquireUpload(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var image *ImageInfo
+	var saveErr error
+	session, err := server.uploadSessions.Finish(req.GetUploadId(), func(session *UploadSession, imageData *os.File) error {
+		image, saveErr = server.saveImage(ctx, session.LaptopId, session.ImageType, session.Checksum, imageData)
+		return saveErr
+	})
+	if saveErr != nil {
+		// the upload is kept, so the client can finish it again
+		return nil, saveErr
+	}
+	if err != nil {
+		return nil, uploadSessionError("cannot finish upload", err)
+	}
+
+	log.Printf("saved image with id %s and size %d from upload %s", image.Id, image.Size, session.Id)
+
+	return &pb.FinishUploadResponse{
+		Image: toImageInfoMessage(image),
+	}, nil
+}
+
+// acquireUpload waits until fewer than MaxConcurrentUploads uploads are running,
+// and returns the func that ends the upload.
+func (server *LaptopServer) acquireUpload(ctx context.Context) (func(), error) {
+	select {
+	case server.uploads <- struct{}{}:
+		return func() { <-server.uploads }, nil
+	case <-ctx.Done():
+		return nil, contexError(ctx)
+	}
+}
+
+// maxImageSize returns the upload size limit for the role of the caller.
+func (server *LaptopServer) maxImageSize(ctx context.Context) int64 {
+	claims, ok := ClaimsFromContext(ctx)
+	if ok {
+		size, ok := server.config.RoleMaxImageSizes[claims.Role]
+		if ok {
+			return size
+		}
+	}
+
+	return server.config.MaxImageSize
+}
+
+func uploadSessionError(message string, err error) error {
+	code := codes.Internal
+	switch {
+	case errors.Is(err, ErrUploadNotFound):
+		code = codes.NotFound
+	case errors.Is(err, ErrUploadInvalidOffset):
+		code = codes.OutOfRange
+	case errors.Is(err, ErrUploadTooLarge):
+		code = codes.InvalidArgument
+	case errors.Is(err, ErrUploadIncomplete):
+		code = codes.FailedPrecondition
+	case errors.Is(err, ErrUploadChecksumFailed):
+		code = codes.DataLoss
+	}
+
+	return status.Errorf(code, "%s: %v", message, err)
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"pc-book/pb"
+	"pc-book/units"
+)
+
+func validateLaptop(laptop *pb.Laptop) error {
+	if laptop.GetMemory() != nil {
+		err := units.ValidateMemory(laptop.GetMemory())
+		if err != nil {
+			return fmt.Errorf("memory: %w", err)
+		}
+	}
+
+	for i, gpu := range laptop.GetGpus() {
+		if gpu.GetMemory() == nil {
+			continue
+		}
+
+		err := units.ValidateMemory(gpu.GetMemory())
+		if err != nil {
+			return fmt.Errorf("gpus[%d].memory: %w", i, err)
+		}
+	}
+
+	for i, storage := range laptop.GetStorages() {
+		if storage.GetMemory() == nil {
+			continue
+		}
+
+		err := units.ValidateMemory(storage.GetMemory())
+		if err != nil {
+			return fmt.Errorf("storages[%d].memory: %w", i, err)
+		}
+	}
+
+	if units.WeightKg(laptop) < 0 {
+		return fmt.Errorf("weight must not be negative")
+	}
+
+	return nil
+}
+
+func validateFilter(filter *pb.FilterMessage) error {
+	if filter.GetMinRam() != nil {
+		err := units.ValidateMemory(filter.GetMinRam())
+		if err != nil {
+			return fmt.Errorf("min_ram: %w", err)
+		}
+	}
+
+	minAverageRating := filter.GetMinAverageRating()
+	if math.IsNaN(minAverageRating) || minAverageRating < 0 {
+		return fmt.Errorf("min_average_rating must not be negative")
+	}
+
+	return nil
+}
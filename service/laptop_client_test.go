@@ -2,14 +2,28 @@ package service
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"math"
 	"net"
+	"os"
+	"path/filepath"
 	"pc-book/pb"
 	"pc-book/sample"
+	"pc-book/units"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/encoding/protojson"
 )
 
@@ -33,11 +47,583 @@ func TestLaptopClient(t *testing.T) {
 	require.NoError(t, err)
 	require.NotNil(t, other)
 
+	laptop.PerformanceScore, laptop.ValueScore = NewDefaultScorer().Score(laptop)
+	laptop.Price = units.NewMoney(DEFAULT_CURRENCY, laptop.PriceUsd)
 	requireSameLaptop(t, laptop, other)
 }
 
+func TestLaptopClientSearchSorted(t *testing.T) {
+	t.Parallel()
+
+	laptopServer, serverAddr := startLaptopServer(t)
+	laptopClient := newLaptopCient(t, serverAddr)
+
+	for i := 0; i < 5; i++ {
+		err := laptopServer.laptopStore.Save(sample.NewLaptop())
+		require.NoError(t, err)
+	}
+
+	stream, err := laptopClient.SearchLaptop(context.Background(), &pb.SearchLaptopRequest{
+		Filter:     &pb.FilterMessage{MaxPriceUsd: 5000, MinPerformanceScore: 1},
+		SortBy:     pb.SearchLaptopRequest_PERFORMANCE_SCORE,
+		Descending: true,
+	})
+	require.NoError(t, err)
+
+	found := 0
+	previous := math.MaxFloat64
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		score := res.GetLaptop().GetPerformanceScore()
+		require.Greater(t, score, 0.0)
+		require.Greater(t, res.GetLaptop().GetValueScore(), 0.0)
+		require.LessOrEqual(t, score, previous)
+
+		previous = score
+		found++
+	}
+
+	require.Equal(t, 5, found)
+}
+
+func TestLaptopClientSearchCurrency(t *testing.T) {
+	t.Parallel()
+
+	ratesFile := filepath.Join(t.TempDir(), "rates.json")
+	err := os.WriteFile(ratesFile, []byte(`{"base": "USD", "rates": {"EUR": 0.5, "IDR": 15000}}`), 0644)
+	require.NoError(t, err)
+
+	exchangeRates, err := NewFileExchangeRateStore(ratesFile)
+	require.NoError(t, err)
+
+	laptopServer := NewLaptopServer(NewInMemoryLaptopStore(), nil, nil, exchangeRates)
+	serverAddr := serveLaptopServer(t, laptopServer)
+	laptopClient := newLaptopCient(t, serverAddr)
+
+	cheap := sample.NewLaptop()
+	cheap.Price = units.NewMoney("IDR", 15_000_000)
+	expensive := sample.NewLaptop()
+	expensive.Price = units.NewMoney("EUR", 2000)
+
+	for _, laptop := range []*pb.Laptop{expensive, cheap} {
+		_, err := laptopClient.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: laptop})
+		require.NoError(t, err)
+	}
+
+	stored, err := laptopServer.laptopStore.Find(cheap.Id)
+	require.NoError(t, err)
+	require.InDelta(t, 1000, stored.GetPriceUsd(), 1e-6)
+
+	err = os.WriteFile(ratesFile, []byte(`{"base": "USD", "rates": {"EUR": 0.8, "IDR": 15000}}`), 0644)
+	require.NoError(t, err)
+	require.NoError(t, exchangeRates.Reload())
+
+	stream, err := laptopClient.SearchLaptop(context.Background(), &pb.SearchLaptopRequest{
+		Filter: &pb.FilterMessage{
+			MaxPrice: units.NewMoney("EUR", 5000),
+		},
+		SortBy: pb.SearchLaptopRequest_PRICE,
+	})
+	require.NoError(t, err)
+
+	var found []*pb.Laptop
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		found = append(found, res.GetLaptop())
+	}
+
+	require.Len(t, found, 2)
+	require.Equal(t, cheap.Id, found[0].GetId())
+	require.Equal(t, "IDR", found[0].GetPrice().GetCurrencyCode())
+	require.Equal(t, "EUR", found[0].GetConvertedPrice().GetCurrencyCode())
+	require.InDelta(t, 800, units.MoneyToFloat(found[0].GetConvertedPrice()), 1e-6)
+	require.InDelta(t, 2000, units.MoneyToFloat(found[1].GetConvertedPrice()), 1e-6)
+
+	res, err := laptopClient.GetLaptop(context.Background(), &pb.GetLaptopRequest{Id: cheap.Id, CurrencyCode: "eur"})
+	require.NoError(t, err)
+	require.Equal(t, "IDR", res.GetLaptop().GetPrice().GetCurrencyCode())
+	require.Equal(t, "EUR", res.GetLaptop().GetConvertedPrice().GetCurrencyCode())
+	require.InDelta(t, 800, units.MoneyToFloat(res.GetLaptop().GetConvertedPrice()), 1e-6)
+
+	// price_usd is a snapshot taken at creation
+	require.InDelta(t, 1000, res.GetLaptop().GetPriceUsd(), 1e-6)
+
+	_, err = laptopClient.GetLaptop(context.Background(), &pb.GetLaptopRequest{Id: cheap.Id, CurrencyCode: "XYZ"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestLaptopClientSearchRating(t *testing.T) {
+	t.Parallel()
+
+	laptopServer, serverAddr := startLaptopServer(t)
+	laptopClient := newLaptopCient(t, serverAddr)
+
+	good, poor, unrated := sample.NewLaptop(), sample.NewLaptop(), sample.NewLaptop()
+	for _, laptop := range []*pb.Laptop{good, poor, unrated} {
+		require.NoError(t, laptopServer.laptopStore.Save(laptop))
+	}
+
+	for laptopId, score := range map[string]float64{good.Id: 8, poor.Id: 3} {
+		_, err := laptopServer.ratingStore.Add(laptopId, "alice", score)
+		require.NoError(t, err)
+	}
+
+	search := func(req *pb.SearchLaptopRequest) []*pb.Laptop {
+		stream, err := laptopClient.SearchLaptop(context.Background(), req)
+		require.NoError(t, err)
+
+		var found []*pb.Laptop
+		for {
+			res, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+
+			found = append(found, res.GetLaptop())
+		}
+
+		return found
+	}
+
+	found := search(&pb.SearchLaptopRequest{Filter: &pb.FilterMessage{MaxPriceUsd: 5000}})
+	require.Len(t, found, 3)
+	for _, laptop := range found {
+		require.Nil(t, laptop.GetRatingSummary())
+	}
+
+	found = search(&pb.SearchLaptopRequest{
+		Filter:               &pb.FilterMessage{MaxPriceUsd: 5000},
+		SortBy:               pb.SearchLaptopRequest_PRICE,
+		IncludeRatingSummary: true,
+	})
+	require.Len(t, found, 3)
+	for _, laptop := range found {
+		require.NotNil(t, laptop.GetRatingSummary())
+	}
+
+	found = search(&pb.SearchLaptopRequest{
+		Filter:               &pb.FilterMessage{MaxPriceUsd: 5000, MinAverageRating: 5},
+		IncludeRatingSummary: true,
+	})
+	require.Len(t, found, 1)
+	require.Equal(t, good.Id, found[0].GetId())
+	require.Equal(t, 8.0, found[0].GetRatingSummary().GetAverage())
+
+	stream, err := laptopClient.SearchLaptop(context.Background(), &pb.SearchLaptopRequest{
+		Filter: &pb.FilterMessage{MinAverageRating: -1},
+	})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestLaptopClientSearchBatched(t *testing.T) {
+	t.Parallel()
+
+	laptopServer, serverAddr := startLaptopServer(t)
+	laptopClient := newLaptopCient(t, serverAddr)
+
+	for i := 0; i < 25; i++ {
+		err := laptopServer.laptopStore.Save(sample.NewLaptop())
+		require.NoError(t, err)
+	}
+
+	stream, err := laptopClient.SearchLaptop(context.Background(), &pb.SearchLaptopRequest{
+		Filter:    &pb.FilterMessage{MaxPriceUsd: 5000},
+		BatchSize: 10,
+	})
+	require.NoError(t, err)
+
+	found := 0
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		require.Nil(t, res.GetLaptop())
+		require.NotEmpty(t, res.GetLaptops())
+		require.LessOrEqual(t, len(res.GetLaptops()), 10)
+
+		found += len(res.GetLaptops())
+	}
+
+	require.Equal(t, 25, found)
+}
+
+func BenchmarkLaptopClientSearch(b *testing.B) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	laptopServer := NewLaptopServer(NewInMemoryLaptopStore(), nil, nil, nil)
+	laptopClient := newLaptopCient(b, serveLaptopServer(b, laptopServer))
+
+	const size = 10_000
+	for i := 0; i < size; i++ {
+		err := laptopServer.laptopStore.Save(sample.NewLaptop())
+		require.NoError(b, err)
+	}
+
+	for _, batchSize := range []uint32{0, 10, 100, 1000} {
+		b.Run(fmt.Sprintf("batch_size=%d", batchSize), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				stream, err := laptopClient.SearchLaptop(context.Background(), &pb.SearchLaptopRequest{
+					Filter:    &pb.FilterMessage{MaxPriceUsd: 5000},
+					BatchSize: batchSize,
+				})
+				require.NoError(b, err)
+
+				found := 0
+				for {
+					res, err := stream.Recv()
+					if err == io.EOF {
+						break
+					}
+					require.NoError(b, err)
+
+					found += len(res.GetLaptops())
+					if res.GetLaptop() != nil {
+						found++
+					}
+				}
+				require.Equal(b, size, found)
+			}
+
+			b.ReportMetric(float64(size*b.N)/b.Elapsed().Seconds(), "laptops/s")
+		})
+	}
+}
+
+func TestLaptopClientDownloadImage(t *testing.T) {
+	t.Parallel()
+
+	laptopServer, serverAddr := startLaptopServer(t)
+	laptopClient := newLaptopCient(t, serverAddr)
+
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopServer.laptopStore.Save(laptop))
+
+	imageData, err := os.ReadFile("../img/laptop.jpg")
+	require.NoError(t, err)
+
+	imageId := uploadImage(t, laptopClient, laptop.Id, ".jpg", imageData)
+
+	stream, err := laptopClient.DownloadImage(context.Background(), &pb.DownloadImageRequest{ImageId: imageId})
+	require.NoError(t, err)
+
+	res, err := stream.Recv()
+	require.NoError(t, err)
+
+	checksum := sha256.Sum256(imageData)
+	info := res.GetInfo()
+	require.Equal(t, imageId, info.GetImageId())
+	require.Equal(t, laptop.Id, info.GetLaptopId())
+	require.Equal(t, ".jpg", info.GetImageType())
+	require.Equal(t, "image/jpeg", info.GetMimeType())
+	require.NotZero(t, info.GetWidth())
+	require.NotZero(t, info.GetHeight())
+	require.Equal(t, uint64(len(imageData)), info.GetSize())
+	require.Equal(t, hex.EncodeToString(checksum[:]), info.GetChecksum())
+
+	downloaded := []byte{}
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		downloaded = append(downloaded, res.GetChunkData()...)
+	}
+	require.Equal(t, imageData, downloaded)
+
+	stream, err = laptopClient.DownloadImage(context.Background(), &pb.DownloadImageRequest{ImageId: "unknown"})
+	require.NoError(t, err)
+
+	_, err = stream.Recv()
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestLaptopClientUploadImageStripsMetadata(t *testing.T) {
+	t.Parallel()
+
+	laptopServer, serverAddr := startLaptopServer(t)
+	laptopClient := newLaptopCient(t, serverAddr)
+
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopServer.laptopStore.Save(laptop))
+
+	jpegData, err := os.ReadFile("../img/laptop.jpg")
+	require.NoError(t, err)
+	imageData := addJPEGSegment(jpegData, 0xe1, []byte("Exif\x00\x00GPS 52.52N 13.40E"))
+	checksum := sha256.Sum256(imageData)
+
+	res, err := sendImage(t, laptopClient, &pb.ImageInfo{LaptopId: laptop.Id, ImageType: ".jpg", Checksum: hex.EncodeToString(checksum[:])}, imageData)
+	require.NoError(t, err)
+
+	image, err := laptopServer.imageStore.Find(res.GetId())
+	require.NoError(t, err)
+	require.True(t, image.MetadataStripped)
+	require.Equal(t, uint64(len(jpegData)), image.Size)
+
+	stored, err := os.ReadFile(image.Path)
+	require.NoError(t, err)
+	require.Equal(t, jpegData, stored)
+
+	laptopServer.config.StripImageMetadata = false
+
+	res, err = sendImage(t, laptopClient, &pb.ImageInfo{LaptopId: laptop.Id, ImageType: ".jpg"}, imageData)
+	require.NoError(t, err)
+
+	image, err = laptopServer.imageStore.Find(res.GetId())
+	require.NoError(t, err)
+	require.False(t, image.MetadataStripped)
+	require.Equal(t, uint64(len(imageData)), image.Size)
+}
+
+func TestLaptopClientResumableUpload(t *testing.T) {
+	t.Parallel()
+
+	laptopServer, serverAddr := startLaptopServer(t)
+	laptopClient := newLaptopCient(t, serverAddr)
+
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopServer.laptopStore.Save(laptop))
+
+	imageData, err := os.ReadFile("../img/laptop.jpg")
+	require.NoError(t, err)
+
+	session, err := laptopClient.StartUpload(context.Background(), &pb.StartUploadRequest{
+		Info: &pb.ImageInfo{LaptopId: laptop.Id, ImageType: ".jpg"},
+		Size: uint64(len(imageData)),
+	})
+	require.NoError(t, err)
+
+	_, err = laptopClient.UploadChunk(context.Background(), &pb.UploadChunkRequest{
+		UploadId:  session.GetUploadId(),
+		ChunkData: imageData[:1000],
+	})
+	require.NoError(t, err)
+
+	_, err = laptopClient.FinishUpload(context.Background(), &pb.FinishUploadRequest{UploadId: session.GetUploadId()})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	query, err := laptopClient.QueryUpload(context.Background(), &pb.QueryUploadRequest{UploadId: session.GetUploadId()})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1000), query.GetCommittedSize())
+
+	_, err = laptopClient.UploadChunk(context.Background(), &pb.UploadChunkRequest{
+		UploadId:  session.GetUploadId(),
+		Offset:    query.GetCommittedSize(),
+		ChunkData: imageData[query.GetCommittedSize():],
+	})
+	require.NoError(t, err)
+
+	res, err := laptopClient.FinishUpload(context.Background(), &pb.FinishUploadRequest{UploadId: session.GetUploadId()})
+	require.NoError(t, err)
+	require.Equal(t, uint64(len(imageData)), res.GetImage().GetSize())
+
+	image, err := laptopServer.imageStore.Find(res.GetImage().GetImageId())
+	require.NoError(t, err)
+	require.Equal(t, laptop.Id, image.LaptopId)
+}
+
+func TestLaptopClientDeleteLaptop(t *testing.T) {
+	t.Parallel()
+
+	laptopServer, serverAddr := startLaptopServer(t)
+	laptopClient := newLaptopCient(t, serverAddr)
+
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopServer.laptopStore.Save(laptop))
+
+	imageData, err := os.ReadFile("../img/laptop.jpg")
+	require.NoError(t, err)
+
+	_, err = sendImage(t, laptopClient, &pb.ImageInfo{LaptopId: laptop.Id, ImageType: ".jpg", Checksum: strings.Repeat("0", 64)}, imageData)
+	require.Equal(t, codes.DataLoss, status.Code(err))
+
+	checksum := sha256.Sum256(imageData)
+	res, err := sendImage(t, laptopClient, &pb.ImageInfo{LaptopId: laptop.Id, ImageType: ".jpg", Checksum: hex.EncodeToString(checksum[:])}, imageData)
+	require.NoError(t, err)
+
+	image, err := laptopServer.imageStore.Find(res.GetId())
+	require.NoError(t, err)
+
+	deleted, err := laptopClient.DeleteLaptop(context.Background(), &pb.DeleteLaptopRequest{Id: laptop.Id})
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), deleted.GetDeletedImages())
+	require.NoFileExists(t, image.Path)
+
+	found, err := laptopServer.laptopStore.Find(laptop.Id)
+	require.NoError(t, err)
+	require.Nil(t, found)
+
+	_, err = laptopClient.DeleteLaptop(context.Background(), &pb.DeleteLaptopRequest{Id: laptop.Id})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestLaptopClientUploadImageTooLarge(t *testing.T) {
+	t.Parallel()
+
+	imageStore, err := NewDiskImageStore(t.TempDir())
+	require.NoError(t, err)
+
+	config := DefaultLaptopServerConfig()
+	config.MaxImageSize = 10 << 10
+	config.UploadFolder = t.TempDir()
+	laptopServer := NewLaptopServerWithConfig(NewInMemoryLaptopStore(), imageStore, NewInMemoryRatingStore(), nil, config)
+	laptopClient := newLaptopCient(t, serveLaptopServer(t, laptopServer))
+
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopServer.laptopStore.Save(laptop))
+
+	imageData, err := os.ReadFile("../img/laptop.jpg")
+	require.NoError(t, err)
+	require.Greater(t, len(imageData), 10<<10)
+
+	_, err = sendImage(t, laptopClient, &pb.ImageInfo{LaptopId: laptop.Id, ImageType: ".jpg"}, imageData)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	entries, err := os.ReadDir(config.UploadFolder)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func uploadImage(t *testing.T, laptopClient pb.LaptopServiceClient, laptopId, imageType string, imageData []byte) string {
+	res, err := sendImage(t, laptopClient, &pb.ImageInfo{LaptopId: laptopId, ImageType: imageType}, imageData)
+	require.NoError(t, err)
+	require.NotEmpty(t, res.GetId())
+
+	return res.GetId()
+}
+
+func sendImage(t *testing.T, laptopClient pb.LaptopServiceClient, info *pb.ImageInfo, imageData []byte) (*pb.UploadImageResponse, error) {
+	stream, err := laptopClient.UploadImage(context.Background())
+	require.NoError(t, err)
+
+	err = stream.Send(&pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_Info{
+			Info: info,
+		},
+	})
+	require.NoError(t, err)
+
+	for len(imageData) > 0 {
+		n := len(imageData)
+		if n > 1024 {
+			n = 1024
+		}
+
+		err = stream.Send(&pb.UploadImageRequest{
+			Data: &pb.UploadImageRequest_ChunkData{
+				ChunkData: imageData[:n],
+			},
+		})
+		if err == io.EOF {
+			// the server has ended the stream, the reason is returned by CloseAndRecv
+			break
+		}
+		require.NoError(t, err)
+
+		imageData = imageData[n:]
+	}
+
+	return stream.CloseAndRecv()
+}
+
+func TestLaptopClientRateLaptop(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := NewInMemoryLaptopStore()
+	laptopServer := NewLaptopServer(laptopStore, nil, NewInMemoryRatingStore(), nil)
+
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopStore.Save(laptop))
+
+	jwtManager := NewJwtManager("secret", time.Minute)
+	auth := NewAuthInterceptor(jwtManager, map[string][]string{
+		laptopServiceMethod("RateLaptop"): {"user"},
+	})
+
+	grpcServer := grpc.NewServer(grpc.StreamInterceptor(auth.Stream()))
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+
+	listener, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	laptopClient := newLaptopCient(t, listener.Addr().String())
+
+	stream, err := laptopClient.RateLaptop(context.Background())
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	user, err := NewUser("alice", "secret", "user")
+	require.NoError(t, err)
+	accessToken, err := jwtManager.Generate(user)
+	require.NoError(t, err)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", accessToken)
+	stream, err = laptopClient.RateLaptop(ctx)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		laptopId string
+		score    float64
+		code     codes.Code
+		count    uint32
+		average  float64
+	}{
+		{laptop.Id, 8, codes.OK, 1, 8},
+		{"unknown", 5, codes.NotFound, 0, 0},
+		{laptop.Id, math.NaN(), codes.InvalidArgument, 0, 0},
+		{laptop.Id, 11, codes.InvalidArgument, 0, 0},
+		{laptop.Id, 0.5, codes.InvalidArgument, 0, 0},
+		{laptop.Id, 6, codes.OK, 1, 6},
+	}
+
+	for _, tc := range testCases {
+		require.NoError(t, stream.Send(&pb.RateLaptopRequest{LaptopId: tc.laptopId, Score: tc.score}))
+
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, tc.laptopId, res.GetLaptopId())
+		require.Equal(t, tc.code, codes.Code(res.GetStatus().GetCode()))
+		require.Equal(t, tc.count, res.GetRateCount())
+		require.Equal(t, tc.average, res.GetAverageScore())
+	}
+
+	require.NoError(t, stream.CloseSend())
+	_, err = stream.Recv()
+	require.Equal(t, io.EOF, err)
+}
+
 func startLaptopServer(t *testing.T) (*LaptopServer, string) {
-	laptop := NewLaptopServer(NewInMemoryLaptopStore(), NewDiskImageStore(""), NewInMemoryRatingStore())
+	imageStore, err := NewDiskImageStore(t.TempDir())
+	require.NoError(t, err)
+
+	config := DefaultLaptopServerConfig()
+	config.UploadFolder = t.TempDir()
+	laptop := NewLaptopServerWithConfig(NewInMemoryLaptopStore(), imageStore, NewInMemoryRatingStore(), nil, config)
+
+	return laptop, serveLaptopServer(t, laptop)
+}
+
+func serveLaptopServer(t testing.TB, laptop *LaptopServer) string {
 	grpcServer := grpc.NewServer()
 
 	pb.RegisterLaptopServiceServer(grpcServer, laptop)
@@ -47,10 +633,10 @@ func startLaptopServer(t *testing.T) (*LaptopServer, string) {
 
 	go grpcServer.Serve(listener)
 
-	return laptop, listener.Addr().String()
+	return listener.Addr().String()
 }
 
-func newLaptopCient(t *testing.T, serverAddr string) pb.LaptopServiceClient {
+func newLaptopCient(t testing.TB, serverAddr string) pb.LaptopServiceClient {
 	conn, err := grpc.Dial(serverAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
 	require.NoError(t, err)
 
@@ -2,13 +2,19 @@ package service
 
 import (
 	"context"
+	"errors"
 	"pc-book/pb"
 	"pc-book/sample"
+	"pc-book/units"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 )
 
 func TestCreateLaptopService(t *testing.T) {
@@ -66,7 +72,7 @@ func TestCreateLaptopService(t *testing.T) {
 				Laptop: tc.laptop,
 			}
 
-			server := NewLaptopServer(tc.store, nil, nil)
+			server := NewLaptopServer(tc.store, nil, nil, nil)
 
 			res, err := server.CreateLaptop(context.Background(), req)
 			if tc.code == codes.OK {
@@ -86,3 +92,411 @@ func TestCreateLaptopService(t *testing.T) {
 		})
 	}
 }
+
+func TestCompareLaptopsService(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+
+	laptop1 := sample.NewLaptop()
+	laptop1.Memory = &pb.Memory{Value: 8, Unit: pb.Memory_GB}
+	laptop1.Weight = &pb.Laptop_WeightKg{WeightKg: 1}
+	laptop1.PriceUsd = 2000
+
+	laptop2 := sample.NewLaptop()
+	laptop2.Memory = &pb.Memory{Value: 16 << 10, Unit: pb.Memory_MB}
+	laptop2.Weight = &pb.Laptop_WeightLb{WeightLb: 1}
+	laptop2.PriceUsd = 2000
+
+	require.NoError(t, store.Save(laptop1))
+	require.NoError(t, store.Save(laptop2))
+
+	server := NewLaptopServer(store, nil, nil, nil)
+
+	res, err := server.CompareLaptops(context.Background(), &pb.CompareLaptopsRequest{
+		LaptopIds: []string{laptop1.Id, laptop2.Id},
+	})
+	require.NoError(t, err)
+	require.Len(t, res.GetLaptops(), 2)
+
+	attributes := make(map[string]*pb.AttributeComparison)
+	for _, attr := range res.GetAttributes() {
+		attributes[attr.GetName()] = attr
+	}
+
+	memory := attributes["memory"]
+	require.Equal(t, "GB", memory.GetUnit())
+	require.Equal(t, []float64{8, 16}, memory.GetValues())
+	require.Equal(t, []string{laptop2.Id}, memory.GetWinnerIds())
+	require.False(t, memory.GetAllEqual())
+
+	weight := attributes["weight"]
+	require.Equal(t, "kg", weight.GetUnit())
+	require.InDelta(t, 0.4536, weight.GetValues()[1], 1e-4)
+	require.Equal(t, []string{laptop2.Id}, weight.GetWinnerIds())
+
+	price := attributes["price"]
+	require.True(t, price.GetAllEqual())
+	require.Empty(t, price.GetWinnerIds())
+
+	_, err = server.CompareLaptops(context.Background(), &pb.CompareLaptopsRequest{
+		LaptopIds: []string{laptop1.Id},
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = server.CompareLaptops(context.Background(), &pb.CompareLaptopsRequest{
+		LaptopIds: []string{laptop1.Id, sample.NewLaptop().Id},
+	})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestGetLaptopReadMask(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+	laptop := sample.NewLaptop()
+	require.NoError(t, store.Save(laptop))
+
+	server := NewLaptopServer(store, nil, nil, nil)
+
+	res, err := server.GetLaptop(context.Background(), &pb.GetLaptopRequest{
+		Id:       laptop.Id,
+		ReadMask: &fieldmaskpb.FieldMask{Paths: []string{"id", "brand", "name", "price", "cpu.name"}},
+	})
+	require.NoError(t, err)
+
+	other := res.GetLaptop()
+	require.Equal(t, laptop.Id, other.GetId())
+	require.Equal(t, laptop.Brand, other.GetBrand())
+	require.Equal(t, laptop.Name, other.GetName())
+	require.InDelta(t, laptop.PriceUsd, units.MoneyToFloat(other.GetPrice()), 1e-6)
+	require.Equal(t, laptop.Cpu.Name, other.GetCpu().GetName())
+	require.Zero(t, other.GetCpu().GetCoresMunber())
+	require.Empty(t, other.GetGpus())
+	require.Nil(t, other.GetScreen())
+	require.Nil(t, other.GetWeight())
+
+	_, err = server.GetLaptop(context.Background(), &pb.GetLaptopRequest{
+		Id:       laptop.Id,
+		ReadMask: &fieldmaskpb.FieldMask{Paths: []string{"gpus.name"}},
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = server.GetLaptop(context.Background(), &pb.GetLaptopRequest{
+		Id: sample.NewLaptop().Id,
+	})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestExplainSearch(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+	laptop := sample.NewLaptop()
+	laptop.Cpu.MinFreq = 2.1
+	laptop.PriceUsd = 1000
+	require.NoError(t, store.Save(laptop))
+
+	server := NewLaptopServer(store, nil, nil, nil)
+
+	res, err := server.ExplainSearch(context.Background(), &pb.ExplainSearchRequest{
+		Filter:   &pb.FilterMessage{MaxPriceUsd: 2000, MixCpuGhz: 2.5},
+		LaptopId: laptop.Id,
+	})
+	require.NoError(t, err)
+	require.Len(t, res.GetExplanations(), 1)
+
+	explanation := res.GetExplanations()[0]
+	require.False(t, explanation.GetQualified())
+
+	clauses := make(map[string]*pb.ClauseResult)
+	for _, clause := range explanation.GetClauses() {
+		clauses[clause.GetClause()] = clause
+	}
+
+	require.True(t, clauses["max_price_usd"].GetPassed())
+	require.False(t, clauses["mix_cpu_ghz"].GetPassed())
+	require.Equal(t, "cpu.min_freq 2.1 < required 2.5", clauses["mix_cpu_ghz"].GetMessage())
+	require.NotContains(t, clauses, "min_ram")
+
+	res, err = server.ExplainSearch(context.Background(), &pb.ExplainSearchRequest{
+		Filter: &pb.FilterMessage{MaxPriceUsd: 2000},
+	})
+	require.NoError(t, err)
+	require.Len(t, res.GetExplanations(), 1)
+	require.True(t, res.GetExplanations()[0].GetQualified())
+}
+
+func TestSuggest(t *testing.T) {
+	t.Parallel()
+
+	server := NewLaptopServer(NewInMemoryLaptopStore(), nil, nil, nil)
+
+	for _, brand := range []string{"Lenovo", "Lenovo", "LG", "Dell"} {
+		laptop := sample.NewLaptop()
+		laptop.Brand = brand
+		_, err := server.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: laptop})
+		require.NoError(t, err)
+	}
+
+	res, err := server.Suggest(context.Background(), &pb.SuggestRequest{Prefix: "l", Field: pb.SuggestRequest_BRAND})
+	require.NoError(t, err)
+	require.Len(t, res.GetSuggestions(), 2)
+	require.Equal(t, "Lenovo", res.GetSuggestions()[0].GetText())
+	require.Equal(t, uint32(2), res.GetSuggestions()[0].GetCount())
+	require.Equal(t, "LG", res.GetSuggestions()[1].GetText())
+
+	res, err = server.Suggest(context.Background(), &pb.SuggestRequest{Prefix: "lenvo", Field: pb.SuggestRequest_BRAND})
+	require.NoError(t, err)
+	require.Len(t, res.GetSuggestions(), 1)
+	require.Equal(t, "Lenovo", res.GetSuggestions()[0].GetText())
+	require.True(t, res.GetSuggestions()[0].GetFuzzy())
+
+	_, err = server.Suggest(context.Background(), &pb.SuggestRequest{Prefix: "l"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// laptops saved to the store directly are indexed too
+	store := NewInMemoryLaptopStore()
+	server = NewLaptopServer(store, nil, nil, nil)
+
+	laptop := sample.NewLaptop()
+	laptop.Brand = "Asus"
+	require.NoError(t, store.Save(laptop))
+
+	res, err = server.Suggest(context.Background(), &pb.SuggestRequest{Prefix: "as", Field: pb.SuggestRequest_BRAND})
+	require.NoError(t, err)
+	require.Len(t, res.GetSuggestions(), 1)
+	require.Equal(t, "Asus", res.GetSuggestions()[0].GetText())
+
+	require.NoError(t, store.Delete(laptop.Id))
+
+	res, err = server.Suggest(context.Background(), &pb.SuggestRequest{Prefix: "as", Field: pb.SuggestRequest_BRAND})
+	require.NoError(t, err)
+	require.Empty(t, res.GetSuggestions())
+}
+
+func TestUploadLimits(t *testing.T) {
+	t.Parallel()
+
+	config := DefaultLaptopServerConfig()
+	config.MaxImageSize = 1000
+	config.RoleMaxImageSizes = map[string]int64{"admin": 5000}
+	config.MaxConcurrentUploads = 1
+	server := NewLaptopServerWithConfig(NewInMemoryLaptopStore(), nil, nil, nil, config)
+
+	adminCtx := contextWithClaims(context.Background(), &UserClaims{Username: "admin", Role: "admin"})
+	userCtx := contextWithClaims(context.Background(), &UserClaims{Username: "user1", Role: "user"})
+
+	require.Equal(t, int64(5000), server.maxImageSize(adminCtx))
+	require.Equal(t, int64(1000), server.maxImageSize(userCtx))
+	require.Equal(t, int64(1000), server.maxImageSize(context.Background()))
+
+	release, err := server.acquireUpload(context.Background())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = server.acquireUpload(ctx)
+	require.Equal(t, codes.DeadlineExceeded, status.Code(err))
+
+	release()
+
+	release, err = server.acquireUpload(context.Background())
+	require.NoError(t, err)
+	release()
+
+	server = NewLaptopServerWithConfig(NewInMemoryLaptopStore(), nil, nil, nil, LaptopServerConfig{
+		MaxImageSize:      -1,
+		RoleMaxImageSizes: map[string]int64{"admin": 0},
+	})
+	require.Equal(t, DEFAULT_MAX_CONCURRENT_UPLOADS, cap(server.uploads))
+	require.Equal(t, int64(DEFAULT_MAX_IMAGE_SIZE), server.maxImageSize(adminCtx))
+	require.Equal(t, int64(DEFAULT_MAX_IMAGE_SIZE), server.maxImageSize(userCtx))
+	require.Equal(t, DEFAULT_MAX_IMAGE_WIDTH, server.imageValidator.maxWidth)
+	require.Equal(t, DEFAULT_MAX_IMAGE_HEIGHT, server.imageValidator.maxHeight)
+	require.Equal(t, float64(DEFAULT_MIN_RATING_SCORE), server.config.MinRatingScore)
+	require.Equal(t, float64(DEFAULT_MAX_RATING_SCORE), server.config.MaxRatingScore)
+}
+
+func TestMyRating(t *testing.T) {
+	t.Parallel()
+
+	ratingStore := NewInMemoryRatingStore()
+	server := NewLaptopServer(NewInMemoryLaptopStore(), nil, ratingStore, nil)
+	laptopId := "laptop-1"
+
+	rating, err := ratingStore.Add(laptopId, "alice", 8)
+	require.NoError(t, err)
+	require.Equal(t, &Rating{Count: 1, Sum: 8}, rating)
+
+	rating, err = ratingStore.Add(laptopId, "bob", 4)
+	require.NoError(t, err)
+	require.Equal(t, &Rating{Count: 2, Sum: 12}, rating)
+
+	rating, err = ratingStore.Add(laptopId, "alice", 6)
+	require.NoError(t, err)
+	require.Equal(t, &Rating{Count: 2, Sum: 10}, rating)
+
+	alice := contextWithClaims(context.Background(), &UserClaims{Username: "alice", Role: "user"})
+
+	res, err := server.GetMyRating(alice, &pb.GetMyRatingRequest{LaptopId: laptopId})
+	require.NoError(t, err)
+	require.Equal(t, 6.0, res.GetScore())
+	require.NotNil(t, res.GetRatedAt())
+
+	_, err = server.GetMyRating(context.Background(), &pb.GetMyRatingRequest{LaptopId: laptopId})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	deleted, err := server.DeleteMyRating(alice, &pb.DeleteMyRatingRequest{LaptopId: laptopId})
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), deleted.GetRateCount())
+	require.Equal(t, 4.0, deleted.GetAverageScore())
+
+	_, err = server.GetMyRating(alice, &pb.GetMyRatingRequest{LaptopId: laptopId})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = server.DeleteMyRating(alice, &pb.DeleteMyRatingRequest{LaptopId: laptopId})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestRatingSumDoesNotDrift(t *testing.T) {
+	t.Parallel()
+
+	ratingStore := NewInMemoryRatingStore()
+	laptopId := "laptop-1"
+
+	_, err := ratingStore.Add(laptopId, "bob", 1e9)
+	require.NoError(t, err)
+	for _, score := range []float64{0.1, 0.2, 0.3, 0.7} {
+		_, err = ratingStore.Add(laptopId, "alice", score)
+		require.NoError(t, err)
+	}
+
+	rating, err := ratingStore.Delete(laptopId, "bob")
+	require.NoError(t, err)
+	require.Equal(t, &Rating{Count: 1, Sum: 0.7}, rating)
+}
+
+func TestGetLaptopRating(t *testing.T) {
+	t.Parallel()
+
+	ratingStore := NewInMemoryRatingStore()
+	laptopStore := NewInMemoryLaptopStore()
+	server := NewLaptopServer(laptopStore, nil, ratingStore, nil)
+
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopStore.Save(laptop))
+
+	res, err := server.GetLaptopRating(context.Background(), &pb.GetLaptopRatingRequest{Id: laptop.Id})
+	require.NoError(t, err)
+	require.Equal(t, uint32(0), res.GetSummary().GetCount())
+	require.Len(t, res.GetSummary().GetHistogram(), 10)
+
+	for username, score := range map[string]float64{"alice": 2, "bob": 4, "carol": 4.4, "dave": 9.6} {
+		_, err := ratingStore.Add(laptop.Id, username, score)
+		require.NoError(t, err)
+	}
+
+	res, err = server.GetLaptopRating(context.Background(), &pb.GetLaptopRatingRequest{Id: laptop.Id})
+	require.NoError(t, err)
+
+	summary := res.GetSummary()
+	require.Equal(t, uint32(4), summary.GetCount())
+	require.InDelta(t, 5, summary.GetAverage(), 1e-9)
+	require.InDelta(t, 4.2, summary.GetMedian(), 1e-9)
+	require.InDelta(t, 2.8071, summary.GetStddev(), 1e-4)
+
+	counts := map[float64]uint32{}
+	for _, bucket := range summary.GetHistogram() {
+		counts[bucket.GetScore()] = bucket.GetCount()
+	}
+	require.Equal(t, map[float64]uint32{1: 0, 2: 1, 3: 0, 4: 2, 5: 0, 6: 0, 7: 0, 8: 0, 9: 0, 10: 1}, counts)
+
+	_, err = server.GetLaptopRating(context.Background(), &pb.GetLaptopRatingRequest{Id: "unknown"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+// failingSearchStore finds one laptop and then fails the search.
+type failingSearchStore struct {
+	LaptopStore
+}
+
+func (store failingSearchStore) Search(ctx context.Context, filter *pb.FilterMessage, found func(laptop *pb.Laptop) error) error {
+	err := found(sample.NewLaptop())
+	if err != nil {
+		return err
+	}
+
+	return errors.New("search failed")
+}
+
+// recordingSearchStream records the responses sent by SearchLaptop.
+type recordingSearchStream struct {
+	grpc.ServerStream
+	mutex     sync.Mutex
+	responses []*pb.SearchLaptopResponse
+}
+
+func (stream *recordingSearchStream) Context() context.Context {
+	return context.Background()
+}
+
+func (stream *recordingSearchStream) Send(res *pb.SearchLaptopResponse) error {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+
+	stream.responses = append(stream.responses, res)
+	return nil
+}
+
+func TestSearchLaptopBatchedFailure(t *testing.T) {
+	t.Parallel()
+
+	server := NewLaptopServer(failingSearchStore{NewInMemoryLaptopStore()}, nil, nil, nil)
+	stream := &recordingSearchStream{}
+
+	err := server.SearchLaptop(&pb.SearchLaptopRequest{
+		Filter:    &pb.FilterMessage{MaxPriceUsd: 5000},
+		BatchSize: 10,
+	}, stream)
+	require.Equal(t, codes.Internal, status.Code(err))
+
+	// the pending batch must not be sent after the search has returned
+	time.Sleep(3 * SEARCH_BATCH_LATENCY)
+
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+	require.Empty(t, stream.responses)
+}
+
+func TestGalleryUnknownLaptop(t *testing.T) {
+	t.Parallel()
+
+	imageStore, err := NewDiskImageStore(t.TempDir())
+	require.NoError(t, err)
+
+	laptopStore := NewInMemoryLaptopStore()
+	server := NewLaptopServer(laptopStore, imageStore, nil, nil)
+
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopStore.Save(laptop))
+
+	res, err := server.ListLaptopImages(context.Background(), &pb.ListLaptopImagesRequest{LaptopId: laptop.Id})
+	require.NoError(t, err)
+	require.Empty(t, res.GetImages())
+
+	_, err = server.ListLaptopImages(context.Background(), &pb.ListLaptopImagesRequest{LaptopId: "unknown"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = server.SetPrimaryImage(context.Background(), &pb.SetPrimaryImageRequest{LaptopId: "unknown", ImageId: "image"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = server.ReorderImages(context.Background(), &pb.ReorderImagesRequest{LaptopId: "unknown"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	server = NewLaptopServer(laptopStore, nil, nil, nil)
+
+	_, err = server.ListLaptopImages(context.Background(), &pb.ListLaptopImagesRequest{LaptopId: laptop.Id})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	_, err = server.DeleteImage(context.Background(), &pb.DeleteImageRequest{ImageId: "image"})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"pc-book/pb"
+	"pc-book/sample"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInMemoryLaptopStoreSearch(t *testing.T) {
+	t.Parallel()
+
+	store := NewShardedInMemoryLaptopStore(8, 3, NewDefaultScorer())
+	filter := &pb.FilterMessage{MaxPriceUsd: 5000}
+
+	expected := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		laptop := sample.NewLaptop()
+		require.NoError(t, store.Save(laptop))
+		expected[laptop.Id] = true
+	}
+
+	found := make(map[string]bool)
+	err := store.Search(context.Background(), filter, func(laptop *pb.Laptop) error {
+		require.False(t, found[laptop.Id])
+		found[laptop.Id] = true
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, expected, found)
+
+	errStop := errors.New("stop")
+	count := 0
+	err = store.Search(context.Background(), filter, func(laptop *pb.Laptop) error {
+		count++
+		return errStop
+	})
+	require.ErrorIs(t, err, errStop)
+	require.Equal(t, 1, count)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = store.Search(ctx, filter, func(laptop *pb.Laptop) error {
+		return nil
+	})
+	require.Error(t, err)
+}
+
+func BenchmarkInMemoryLaptopStoreSearch(b *testing.B) {
+	filter := &pb.FilterMessage{
+		MaxPriceUsd: 2500,
+		CpuCores:    4,
+		MixCpuGhz:   2.5,
+		MinRam:      &pb.Memory{Value: 4, Unit: pb.Memory_GB},
+	}
+
+	for _, size := range []int{1_000, 100_000, 1_000_000} {
+		for _, shards := range []int{1, DEFAULT_SHARD_COUNT} {
+			b.Run(fmt.Sprintf("laptops=%d/shards=%d", size, shards), func(b *testing.B) {
+				store := NewShardedInMemoryLaptopStore(shards, shards, NewDefaultScorer())
+				for i := 0; i < size; i++ {
+					require.NoError(b, store.Save(sample.NewLaptop()))
+				}
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					err := store.Search(context.Background(), filter, func(laptop *pb.Laptop) error {
+						return nil
+					})
+					require.NoError(b, err)
+				}
+			})
+		}
+	}
+}
@@ -1,7 +1,9 @@
 package service
 
 import (
+	"fmt"
 	"sync"
+	"time"
 )
 
 type Rating struct {
@@ -9,38 +11,135 @@ type Rating struct {
 	Sum   float64
 }
 
+// UserRating is the score a user gave to a laptop.
+type UserRating struct {
+	LaptopId string
+	Username string
+	Score    float64
+	RatedAt  time.Time
+}
+
 type RatingStore interface {
-	Add(laptopId string, score float64) (*Rating, error)
+	// Add records the score of a user for a laptop, replacing the previous score of the user,
+	// and returns the updated rating of the laptop.
+	Add(laptopId, username string, score float64) (*Rating, error)
+	// Find returns the score of a user for a laptop, or nil if the user has not rated it.
+	Find(laptopId, username string) (*UserRating, error)
+	// Delete removes the score of a user for a laptop and returns the updated rating of the laptop.
+	Delete(laptopId, username string) (*Rating, error)
+	// Scores returns all the scores given to a laptop, in no particular order.
+	Scores(laptopId string) ([]float64, error)
+}
+
+// laptopRating holds the scores of a laptop by user, and their aggregate.
+type laptopRating struct {
+	Rating
+	scores map[string]*UserRating
+}
+
+// sum recomputes the sum of the scores, so that replacing and deleting scores
+// does not accumulate rounding errors.
+func (rating *laptopRating) sum() {
+	rating.Sum = 0
+	for _, userRating := range rating.scores {
+		rating.Sum += userRating.Score
+	}
 }
 
 type InMemoryRatingStore struct {
 	mutex  sync.RWMutex
-	rating map[string]*Rating
+	rating map[string]*laptopRating
 }
 
 func NewInMemoryRatingStore() RatingStore {
 	return &InMemoryRatingStore{
-		rating: make(map[string]*Rating),
+		rating: make(map[string]*laptopRating),
 	}
 }
 
 // Add implements RatingStore.
-func (store *InMemoryRatingStore) Add(laptopId string, score float64) (*Rating, error) {
+func (store *InMemoryRatingStore) Add(laptopId, username string, score float64) (*Rating, error) {
 	store.mutex.Lock()
 	defer store.mutex.Unlock()
 
 	rating := store.rating[laptopId]
 	if rating == nil {
-		rating = &Rating{
-			Count: 1,
-			Sum:   score,
+		rating = &laptopRating{
+			scores: make(map[string]*UserRating),
 		}
-	} else {
+		store.rating[laptopId] = rating
+	}
+
+	previous := rating.scores[username]
+	rating.scores[username] = &UserRating{
+		LaptopId: laptopId,
+		Username: username,
+		Score:    score,
+		RatedAt:  time.Now(),
+	}
+
+	if previous == nil {
 		rating.Count++
 		rating.Sum += score
+	} else {
+		rating.sum()
+	}
+
+	aggregate := rating.Rating
+	return &aggregate, nil
+}
+
+// Find implements RatingStore.
+func (store *InMemoryRatingStore) Find(laptopId, username string) (*UserRating, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	rating := store.rating[laptopId]
+	if rating == nil || rating.scores[username] == nil {
+		return nil, nil
 	}
 
-	store.rating[laptopId] = rating
+	userRating := *rating.scores[username]
+	return &userRating, nil
+}
+
+// Delete implements RatingStore.
+func (store *InMemoryRatingStore) Delete(laptopId, username string) (*Rating, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	rating := store.rating[laptopId]
+	if rating == nil || rating.scores[username] == nil {
+		return nil, fmt.Errorf("%w: rating of laptop %s by %s", ErrNotFound, laptopId, username)
+	}
+
+	rating.Count--
+	delete(rating.scores, username)
+	rating.sum()
+
+	if rating.Count == 0 {
+		delete(store.rating, laptopId)
+		return &Rating{}, nil
+	}
+
+	aggregate := rating.Rating
+	return &aggregate, nil
+}
+
+// Scores implements RatingStore.
+func (store *InMemoryRatingStore) Scores(laptopId string) ([]float64, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	rating := store.rating[laptopId]
+	if rating == nil {
+		return nil, nil
+	}
+
+	scores := make([]float64, 0, len(rating.scores))
+	for _, userRating := range rating.scores {
+		scores = append(scores, userRating.Score)
+	}
 
-	return rating, nil
+	return scores, nil
 }
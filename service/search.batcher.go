@@ -0,0 +1,117 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"pc-book/pb"
+	"sync"
+	"time"
+)
+
+const SEARCH_BATCH_LATENCY = 50 * time.Millisecond
+
+var errSearchBatcherStopped = errors.New("search batcher is stopped")
+
+// searchBatcher groups found laptops into batched search responses.
+// A batch is sent once it is full or SEARCH_BATCH_LATENCY after its first laptop was added.
+type searchBatcher struct {
+	mutex     sync.Mutex
+	stream    pb.LaptopService_SearchLaptopServer
+	batchSize int
+	latency   time.Duration
+	laptops   []*pb.Laptop
+	timer     *time.Timer
+	err       error
+	stopped   bool
+}
+
+func newSearchBatcher(stream pb.LaptopService_SearchLaptopServer, batchSize int, latency time.Duration) *searchBatcher {
+	return &searchBatcher{
+		stream:    stream,
+		batchSize: batchSize,
+		latency:   latency,
+		laptops:   make([]*pb.Laptop, 0, batchSize),
+	}
+}
+
+func (batcher *searchBatcher) add(laptop *pb.Laptop) error {
+	batcher.mutex.Lock()
+	defer batcher.mutex.Unlock()
+
+	if batcher.stopped {
+		return errSearchBatcherStopped
+	}
+	if batcher.err != nil {
+		return batcher.err
+	}
+
+	batcher.laptops = append(batcher.laptops, laptop)
+	if len(batcher.laptops) >= batcher.batchSize {
+		return batcher.flushLocked()
+	}
+
+	if batcher.timer == nil {
+		batcher.timer = time.AfterFunc(batcher.latency, func() {
+			batcher.mutex.Lock()
+			defer batcher.mutex.Unlock()
+
+			batcher.timer = nil
+			if batcher.err == nil && !batcher.stopped {
+				batcher.err = batcher.flushLocked()
+			}
+		})
+	}
+
+	return nil
+}
+
+func (batcher *searchBatcher) flush() error {
+	batcher.mutex.Lock()
+	defer batcher.mutex.Unlock()
+
+	if batcher.stopped {
+		return errSearchBatcherStopped
+	}
+	if batcher.err != nil {
+		return batcher.err
+	}
+
+	return batcher.flushLocked()
+}
+
+// stop cancels the pending timer and drops the unsent laptops, so that nothing is sent
+// to the stream once the search has returned. It must be called when the search ends.
+func (batcher *searchBatcher) stop() {
+	batcher.mutex.Lock()
+	defer batcher.mutex.Unlock()
+
+	if batcher.timer != nil {
+		batcher.timer.Stop()
+		batcher.timer = nil
+	}
+
+	batcher.stopped = true
+	batcher.laptops = nil
+}
+
+func (batcher *searchBatcher) flushLocked() error {
+	if batcher.timer != nil {
+		batcher.timer.Stop()
+		batcher.timer = nil
+	}
+
+	if len(batcher.laptops) == 0 {
+		return nil
+	}
+
+	err := batcher.stream.Send(&pb.SearchLaptopResponse{Laptops: batcher.laptops})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("send batch of %d laptops", len(batcher.laptops))
+
+	batcher.laptops = make([]*pb.Laptop, 0, batcher.batchSize)
+
+	return nil
+}
@@ -0,0 +1,241 @@
+package service
+
+import (
+	"pc-book/pb"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type suggestionTerm struct {
+	text  string
+	count uint32
+}
+
+// suggestionField is a sorted index of the lower-cased terms of one laptop field.
+type suggestionField struct {
+	keys  []string
+	terms map[string]*suggestionTerm
+}
+
+type InMemorySuggestionIndex struct {
+	mutex  sync.RWMutex
+	fields map[pb.SuggestRequest_Field]*suggestionField
+}
+
+func NewInMemorySuggestionIndex() *InMemorySuggestionIndex {
+	return &InMemorySuggestionIndex{
+		fields: make(map[pb.SuggestRequest_Field]*suggestionField),
+	}
+}
+
+// Add indexes the brand, name, CPU name and GPU names of a laptop.
+func (index *InMemorySuggestionIndex) Add(laptop *pb.Laptop) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	index.addTerm(pb.SuggestRequest_BRAND, laptop.GetBrand())
+	index.addTerm(pb.SuggestRequest_NAME, laptop.GetName())
+	index.addTerm(pb.SuggestRequest_CPU_NAME, laptop.GetCpu().GetName())
+	for _, gpu := range laptop.GetGpus() {
+		index.addTerm(pb.SuggestRequest_GPU_NAME, gpu.GetName())
+	}
+}
+
+// Remove drops the terms of a laptop, keeping the ones other laptops still use.
+func (index *InMemorySuggestionIndex) Remove(laptop *pb.Laptop) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	index.removeTerm(pb.SuggestRequest_BRAND, laptop.GetBrand())
+	index.removeTerm(pb.SuggestRequest_NAME, laptop.GetName())
+	index.removeTerm(pb.SuggestRequest_CPU_NAME, laptop.GetCpu().GetName())
+	for _, gpu := range laptop.GetGpus() {
+		index.removeTerm(pb.SuggestRequest_GPU_NAME, gpu.GetName())
+	}
+}
+
+func (index *InMemorySuggestionIndex) removeTerm(field pb.SuggestRequest_Field, text string) {
+	terms := index.fields[field]
+	if terms == nil {
+		return
+	}
+
+	key := strings.ToLower(strings.TrimSpace(text))
+	term := terms.terms[key]
+	if term == nil {
+		return
+	}
+
+	term.count--
+	if term.count > 0 {
+		return
+	}
+
+	delete(terms.terms, key)
+
+	i := sort.SearchStrings(terms.keys, key)
+	if i < len(terms.keys) && terms.keys[i] == key {
+		terms.keys = append(terms.keys[:i], terms.keys[i+1:]...)
+	}
+}
+
+func (index *InMemorySuggestionIndex) addTerm(field pb.SuggestRequest_Field, text string) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return
+	}
+
+	terms := index.fields[field]
+	if terms == nil {
+		terms = &suggestionField{terms: make(map[string]*suggestionTerm)}
+		index.fields[field] = terms
+	}
+
+	key := strings.ToLower(text)
+	term := terms.terms[key]
+	if term != nil {
+		term.count++
+		return
+	}
+
+	terms.terms[key] = &suggestionTerm{text: text, count: 1}
+
+	i := sort.SearchStrings(terms.keys, key)
+	terms.keys = append(terms.keys, "")
+	copy(terms.keys[i+1:], terms.keys[i:])
+	terms.keys[i] = key
+}
+
+// Suggest returns up to limit terms starting with prefix. When there are not enough of them,
+// it fills up with terms whose beginning is within a small edit distance of the prefix.
+func (index *InMemorySuggestionIndex) Suggest(field pb.SuggestRequest_Field, prefix string, limit int) []*pb.Suggestion {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+
+	terms := index.fields[field]
+	if terms == nil || limit <= 0 {
+		return nil
+	}
+
+	prefix = strings.ToLower(strings.TrimSpace(prefix))
+	suggestions := []*pb.Suggestion{}
+
+	matched := make(map[string]bool)
+	for i := sort.SearchStrings(terms.keys, prefix); i < len(terms.keys); i++ {
+		key := terms.keys[i]
+		if !strings.HasPrefix(key, prefix) || len(suggestions) >= limit {
+			break
+		}
+
+		matched[key] = true
+		suggestions = append(suggestions, &pb.Suggestion{
+			Text:  terms.terms[key].text,
+			Count: terms.terms[key].count,
+		})
+	}
+
+	if len(suggestions) >= limit || prefix == "" {
+		return suggestions
+	}
+
+	type candidate struct {
+		key      string
+		distance int
+	}
+
+	maxDistance := maxSuggestDistance(prefix)
+	candidates := []candidate{}
+	for _, key := range terms.keys {
+		if matched[key] {
+			continue
+		}
+
+		distance := prefixDistance(prefix, key)
+		if distance <= maxDistance {
+			candidates = append(candidates, candidate{key: key, distance: distance})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return terms.terms[candidates[i].key].count > terms.terms[candidates[j].key].count
+	})
+
+	for _, c := range candidates {
+		if len(suggestions) >= limit {
+			break
+		}
+
+		suggestions = append(suggestions, &pb.Suggestion{
+			Text:  terms.terms[c.key].text,
+			Count: terms.terms[c.key].count,
+			Fuzzy: true,
+		})
+	}
+
+	return suggestions
+}
+
+func maxSuggestDistance(prefix string) int {
+	switch n := len([]rune(prefix)); {
+	case n < 3:
+		return 0
+	case n < 6:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// prefixDistance is the smallest edit distance between prefix and any beginning of term
+// that is at most one rune shorter or longer than the prefix.
+func prefixDistance(prefix, term string) int {
+	p, t := []rune(prefix), []rune(term)
+
+	best := len(p) + len(t)
+	for n := len(p) - 1; n <= len(p)+1; n++ {
+		if n < 0 || n > len(t) {
+			continue
+		}
+
+		distance := editDistance(p, t[:n])
+		if distance < best {
+			best = distance
+		}
+	}
+
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
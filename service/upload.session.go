@@ -0,0 +1,348 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DEFAULT_UPLOAD_FOLDER         = "tmp/uploads"
+	DEFAULT_UPLOAD_SESSION_TTL    = time.Hour
+	DEFAULT_UPLOAD_SWEEP_INTERVAL = 5 * time.Minute
+	UPLOAD_SESSION_EXT            = ".upload"
+)
+
+var (
+	ErrUploadNotFound       = errors.New("upload session not found")
+	ErrUploadInvalidOffset  = errors.New("invalid upload offset")
+	ErrUploadTooLarge       = errors.New("upload exceeds its declared size")
+	ErrUploadIncomplete     = errors.New("upload is incomplete")
+	ErrUploadChecksumFailed = errors.New("upload checksum mismatch")
+)
+
+// UploadSession describes a resumable upload. Its bytes are kept in a temp file until the upload is finished.
+type UploadSession struct {
+	Id        string
+	LaptopId  string
+	ImageType string
+	Size      uint64
+	Checksum  string
+	Committed uint64
+	ExpiresAt time.Time
+}
+
+type UploadSessionStore interface {
+	Start(laptopId, imageType string, size uint64, checksum string) (*UploadSession, error)
+	Write(uploadId string, offset uint64, data []byte) (*UploadSession, error)
+	Find(uploadId string) (*UploadSession, error)
+	Finish(uploadId string, save func(session *UploadSession, imageData *os.File) error) (*UploadSession, error)
+}
+
+type uploadSession struct {
+	mutex   sync.Mutex
+	info    UploadSession
+	path    string
+	removed bool
+}
+
+type DiskUploadSessionStore struct {
+	mutex    sync.Mutex
+	folder   string
+	ttl      time.Duration
+	sessions map[string]*uploadSession
+}
+
+// NewDiskUploadSessionStore creates a session store that keeps partial uploads in folder.
+// Sessions that receive no data for ttl expire and their temp files are removed.
+func NewDiskUploadSessionStore(folder string, ttl time.Duration) *DiskUploadSessionStore {
+	return &DiskUploadSessionStore{
+		folder:   folder,
+		ttl:      ttl,
+		sessions: make(map[string]*uploadSession),
+	}
+}
+
+// Start implements UploadSessionStore.
+func (store *DiskUploadSessionStore) Start(laptopId, imageType string, size uint64, checksum string) (*UploadSession, error) {
+	store.RemoveExpired()
+
+	uploadId, err := uuid.NewRandom()
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate upload id: %w", err)
+	}
+
+	err = os.MkdirAll(store.folder, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create upload folder: %w", err)
+	}
+
+	session := &uploadSession{
+		info: UploadSession{
+			Id:        uploadId.String(),
+			LaptopId:  laptopId,
+			ImageType: imageType,
+			Size:      size,
+			Checksum:  strings.ToLower(checksum),
+			ExpiresAt: time.Now().Add(store.ttl),
+		},
+		path: filepath.Join(store.folder, uploadId.String()+UPLOAD_SESSION_EXT),
+	}
+
+	file, err := os.Create(session.path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create upload file: %w", err)
+	}
+	file.Close()
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.sessions[session.info.Id] = session
+
+	info := session.info
+	return &info, nil
+}
+
+// Write implements UploadSessionStore.
+// The offset may rewind into bytes that are already committed, so a client can retry a chunk,
+// but it cannot leave a gap after the committed bytes.
+func (store *DiskUploadSessionStore) Write(uploadId string, offset uint64, data []byte) (*UploadSession, error) {
+	session, err := store.session(uploadId)
+	if err != nil {
+		return nil, err
+	}
+
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	if session.removed {
+		return nil, fmt.Errorf("%w: %s", ErrUploadNotFound, uploadId)
+	}
+	if offset > session.info.Committed {
+		return nil, fmt.Errorf("%w: offset %d is past the %d committed bytes", ErrUploadInvalidOffset, offset, session.info.Committed)
+	}
+
+	end := offset + uint64(len(data))
+	if end > session.info.Size {
+		return nil, fmt.Errorf("%w: %d > %d bytes", ErrUploadTooLarge, end, session.info.Size)
+	}
+
+	file, err := os.OpenFile(session.path, os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open upload file: %w", err)
+	}
+	defer file.Close()
+
+	_, err = file.WriteAt(data, int64(offset))
+	if err != nil {
+		return nil, fmt.Errorf("cannot write upload file: %w", err)
+	}
+
+	if end > session.info.Committed {
+		session.info.Committed = end
+	}
+	session.info.ExpiresAt = time.Now().Add(store.ttl)
+
+	info := session.info
+	return &info, nil
+}
+
+// Find implements UploadSessionStore.
+func (store *DiskUploadSessionStore) Find(uploadId string) (*UploadSession, error) {
+	session, err := store.session(uploadId)
+	if err != nil {
+		return nil, err
+	}
+
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	info := session.info
+	return &info, nil
+}
+
+// Finish implements UploadSessionStore.
+// It checks the size and checksum of the uploaded bytes and passes them to save. The session
+// is closed once save succeeds; if save fails, the session and its bytes are kept so that the
+// upload can be finished again. A session whose bytes do not match its checksum is closed
+// right away, since retrying cannot fix it.
+func (store *DiskUploadSessionStore) Finish(uploadId string, save func(session *UploadSession, imageData *os.File) error) (*UploadSession, error) {
+	session, err := store.session(uploadId)
+	if err != nil {
+		return nil, err
+	}
+
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	if session.removed {
+		return nil, fmt.Errorf("%w: %s", ErrUploadNotFound, uploadId)
+	}
+	if session.info.Committed != session.info.Size {
+		return nil, fmt.Errorf("%w: %d of %d bytes committed", ErrUploadIncomplete, session.info.Committed, session.info.Size)
+	}
+
+	file, err := os.Open(session.path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open upload file: %w", err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read upload file: %w", err)
+	}
+
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	if session.info.Checksum != "" && session.info.Checksum != checksum {
+		store.remove(session)
+		return nil, fmt.Errorf("%w: got %s, expected %s", ErrUploadChecksumFailed, checksum, session.info.Checksum)
+	}
+
+	info := session.info
+	err = save(&info, file)
+	if err != nil {
+		return nil, err
+	}
+
+	store.remove(session)
+
+	return &info, nil
+}
+
+// RemoveExpired drops the expired sessions, and the temp files older than the session ttl
+// that no session owns, such as the ones left by a previous run.
+func (store *DiskUploadSessionStore) RemoveExpired() {
+	now := time.Now()
+
+	store.mutex.Lock()
+	sessions := []*uploadSession{}
+	for _, session := range store.sessions {
+		sessions = append(sessions, session)
+	}
+	store.mutex.Unlock()
+
+	owned := make(map[string]bool)
+	for _, session := range sessions {
+		session.mutex.Lock()
+		if now.After(session.info.ExpiresAt) {
+			store.remove(session)
+		} else {
+			owned[filepath.Base(session.path)] = true
+		}
+		session.mutex.Unlock()
+	}
+
+	entries, err := os.ReadDir(store.folder)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || owned[entry.Name()] || !strings.HasSuffix(entry.Name(), UPLOAD_SESSION_EXT) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err == nil && now.Sub(info.ModTime()) > store.ttl {
+			os.Remove(filepath.Join(store.folder, entry.Name()))
+		}
+	}
+}
+
+// Run removes the expired sessions every interval until ctx is done, so that abandoned uploads
+// do not wait for the next Start to be cleaned up.
+func (store *DiskUploadSessionStore) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		store.RemoveExpired()
+	}
+}
+
+// session returns a live session, removing it if it has expired.
+func (store *DiskUploadSessionStore) session(uploadId string) (*uploadSession, error) {
+	store.mutex.Lock()
+	session := store.sessions[uploadId]
+	store.mutex.Unlock()
+
+	if session == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUploadNotFound, uploadId)
+	}
+
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	if time.Now().After(session.info.ExpiresAt) {
+		store.remove(session)
+	}
+	if session.removed {
+		return nil, fmt.Errorf("%w: %s", ErrUploadNotFound, uploadId)
+	}
+
+	return session, nil
+}
+
+// remove closes a session and deletes its temp file. The caller must hold the session lock.
+func (store *DiskUploadSessionStore) remove(session *uploadSession) {
+	if session.removed {
+		return
+	}
+
+	session.removed = true
+	os.Remove(session.path)
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	delete(store.sessions, session.info.Id)
+}
+
+// tempFile is a file that is deleted once it is closed.
+type tempFile struct {
+	*os.File
+}
+
+// createTempFile creates a tempFile in folder, creating the folder if needed.
+func createTempFile(folder, pattern string) (*tempFile, error) {
+	err := os.MkdirAll(folder, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.CreateTemp(folder, pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tempFile{file}, nil
+}
+
+func (file *tempFile) Close() error {
+	err := file.File.Close()
+	os.Remove(file.Name())
+
+	return err
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func saveNothing(session *UploadSession, imageData *os.File) error {
+	return nil
+}
+
+func TestDiskUploadSessionStore(t *testing.T) {
+	t.Parallel()
+
+	data := []byte("resumable image data")
+	checksum := sha256.Sum256(data)
+
+	folder := t.TempDir()
+	store := NewDiskUploadSessionStore(folder, time.Minute)
+
+	session, err := store.Start("laptop-1", ".jpg", uint64(len(data)), hex.EncodeToString(checksum[:]))
+	require.NoError(t, err)
+
+	_, err = store.Write(session.Id, 4, data[4:])
+	require.ErrorIs(t, err, ErrUploadInvalidOffset)
+
+	session, err = store.Write(session.Id, 0, data[:10])
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), session.Committed)
+
+	_, err = store.Finish(session.Id, saveNothing)
+	require.ErrorIs(t, err, ErrUploadIncomplete)
+
+	session, err = store.Write(session.Id, 5, data[5:])
+	require.NoError(t, err)
+	require.Equal(t, uint64(len(data)), session.Committed)
+
+	_, err = store.Write(session.Id, 5, append(data[5:], 'x'))
+	require.ErrorIs(t, err, ErrUploadTooLarge)
+
+	saveErr := errors.New("cannot save image")
+	_, err = store.Finish(session.Id, func(session *UploadSession, imageData *os.File) error {
+		return saveErr
+	})
+	require.ErrorIs(t, err, saveErr)
+
+	// a failed save keeps the upload, so it can be finished again
+	_, err = store.Find(session.Id)
+	require.NoError(t, err)
+
+	var uploaded []byte
+	finished, err := store.Finish(session.Id, func(session *UploadSession, imageData *os.File) error {
+		uploaded, err = io.ReadAll(imageData)
+		return err
+	})
+	require.NoError(t, err)
+	require.Equal(t, "laptop-1", finished.LaptopId)
+	require.Equal(t, data, uploaded)
+
+	entries, err := os.ReadDir(folder)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	_, err = store.Find(session.Id)
+	require.ErrorIs(t, err, ErrUploadNotFound)
+}
+
+func TestDiskUploadSessionStoreChecksum(t *testing.T) {
+	t.Parallel()
+
+	store := NewDiskUploadSessionStore(t.TempDir(), time.Minute)
+
+	session, err := store.Start("laptop-1", ".jpg", 4, hex.EncodeToString(make([]byte, sha256.Size)))
+	require.NoError(t, err)
+
+	_, err = store.Write(session.Id, 0, []byte("data"))
+	require.NoError(t, err)
+
+	_, err = store.Finish(session.Id, saveNothing)
+	require.ErrorIs(t, err, ErrUploadChecksumFailed)
+
+	_, err = store.Find(session.Id)
+	require.ErrorIs(t, err, ErrUploadNotFound)
+}
+
+func TestDiskUploadSessionStoreExpire(t *testing.T) {
+	t.Parallel()
+
+	folder := t.TempDir()
+	store := NewDiskUploadSessionStore(folder, 10*time.Millisecond)
+
+	session, err := store.Start("laptop-1", ".jpg", 4, "")
+	require.NoError(t, err)
+
+	time.Sleep(20 * time.Millisecond)
+
+	_, err = store.Write(session.Id, 0, []byte("data"))
+	require.ErrorIs(t, err, ErrUploadNotFound)
+
+	entries, err := os.ReadDir(folder)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestDiskUploadSessionStoreRun(t *testing.T) {
+	t.Parallel()
+
+	folder := t.TempDir()
+	store := NewDiskUploadSessionStore(folder, 10*time.Millisecond)
+
+	_, err := store.Start("laptop-1", ".jpg", 4, "")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go store.Run(ctx, 5*time.Millisecond)
+
+	require.Eventually(t, func() bool {
+		entries, err := os.ReadDir(folder)
+		return err == nil && len(entries) == 0
+	}, time.Second, 5*time.Millisecond)
+}
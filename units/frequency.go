@@ -0,0 +1,44 @@
+package units
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CPU and GPU frequencies are stored in GHz.
+
+func MHzToGHz(mhz float64) float64 {
+	return mhz / 1000
+}
+
+func GHzToMHz(ghz float64) float64 {
+	return ghz * 1000
+}
+
+// ParseFrequency parses a frequency such as "2.4GHz" or "800 MHz" and returns it in GHz.
+func ParseFrequency(s string) (float64, error) {
+	number, symbol := splitNumber(s)
+
+	value, err := parseFloat(number, s)
+	if err != nil {
+		return 0, err
+	}
+
+	switch strings.ToLower(symbol) {
+	case "ghz":
+		return value, nil
+	case "mhz":
+		return MHzToGHz(value), nil
+	default:
+		return 0, fmt.Errorf("%w: unknown frequency unit %q", ErrInvalid, symbol)
+	}
+}
+
+// FormatFrequency formats a frequency in GHz, switching to MHz below 1 GHz.
+func FormatFrequency(ghz float64) string {
+	if ghz > 0 && ghz < 1 {
+		return fmt.Sprintf("%.0f MHz", GHzToMHz(ghz))
+	}
+
+	return fmt.Sprintf("%.2f GHz", ghz)
+}
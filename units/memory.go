@@ -0,0 +1,269 @@
+package units
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"pc-book/pb"
+)
+
+const (
+	BitsPerByte = 8
+	BytesPerGB  = 1 << 30
+)
+
+var (
+	ErrUnknownUnit = errors.New("unknown memory unit")
+	ErrOverflow    = errors.New("memory value overflows")
+	ErrInvalid     = errors.New("invalid value")
+)
+
+// memoryShift is the number of bits each memory unit is shifted by when converted into bits.
+var memoryShift = map[pb.Memory_Unit]uint{
+	pb.Memory_BIT:  0,
+	pb.Memory_BYTE: 3,
+	pb.Memory_KB:   13,
+	pb.Memory_MB:   23,
+	pb.Memory_GB:   33,
+	pb.Memory_TB:   43,
+}
+
+var memorySymbol = map[pb.Memory_Unit]string{
+	pb.Memory_BIT:  "bit",
+	pb.Memory_BYTE: "B",
+	pb.Memory_KB:   "KB",
+	pb.Memory_MB:   "MB",
+	pb.Memory_GB:   "GB",
+	pb.Memory_TB:   "TB",
+}
+
+// memoryUnits lists the known units from the largest to the smallest.
+var memoryUnits = []pb.Memory_Unit{
+	pb.Memory_TB,
+	pb.Memory_GB,
+	pb.Memory_MB,
+	pb.Memory_KB,
+	pb.Memory_BYTE,
+	pb.Memory_BIT,
+}
+
+// ValidateMemory reports whether memory has a known unit.
+func ValidateMemory(memory *pb.Memory) error {
+	_, ok := memoryShift[memory.GetUnit()]
+	if !ok {
+		return fmt.Errorf("%w: %v", ErrUnknownUnit, memory.GetUnit())
+	}
+
+	return nil
+}
+
+// Bits converts a memory value into bits, failing when the result does not fit into an uint64.
+func Bits(memory *pb.Memory) (uint64, error) {
+	hi, lo, err := bits128(memory)
+	if err != nil {
+		return 0, err
+	}
+	if hi != 0 {
+		return 0, fmt.Errorf("%w: %d %v", ErrOverflow, memory.GetValue(), memory.GetUnit())
+	}
+
+	return lo, nil
+}
+
+// CompareMemory returns -1, 0 or 1 when a is smaller than, equal to or bigger than b.
+func CompareMemory(a, b *pb.Memory) (int, error) {
+	aHi, aLo, err := bits128(a)
+	if err != nil {
+		return 0, err
+	}
+
+	bHi, bLo, err := bits128(b)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case aHi < bHi || (aHi == bHi && aLo < bLo):
+		return -1, nil
+	case aHi > bHi || (aHi == bHi && aLo > bLo):
+		return 1, nil
+	default:
+		return 0, nil
+	}
+}
+
+// AddMemory adds two memory values. The result is expressed in the smaller unit of the two.
+func AddMemory(a, b *pb.Memory) (*pb.Memory, error) {
+	err := ValidateMemory(a)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ValidateMemory(b)
+	if err != nil {
+		return nil, err
+	}
+
+	unit := a.GetUnit()
+	if memoryShift[b.GetUnit()] < memoryShift[unit] {
+		unit = b.GetUnit()
+	}
+
+	aValue, err := convert(a, unit)
+	if err != nil {
+		return nil, err
+	}
+
+	bValue, err := convert(b, unit)
+	if err != nil {
+		return nil, err
+	}
+
+	sum, carry := bits.Add64(aValue, bValue, 0)
+	if carry != 0 {
+		return nil, fmt.Errorf("%w: %d + %d %v", ErrOverflow, aValue, bValue, unit)
+	}
+
+	return &pb.Memory{Value: sum, Unit: unit}, nil
+}
+
+// ToGigabytes converts a memory value into gigabytes. Unknown units are converted to 0.
+func ToGigabytes(memory *pb.Memory) float64 {
+	shift, ok := memoryShift[memory.GetUnit()]
+	if !ok {
+		return 0
+	}
+
+	return float64(memory.GetValue()) * math.Pow(2, float64(shift)) / BitsPerByte / BytesPerGB
+}
+
+// ParseMemory parses a human readable memory value such as "16GB", "1.5 TB" or "512 mb".
+// Fractional values are expressed in the largest unit that keeps the value exact.
+func ParseMemory(s string) (*pb.Memory, error) {
+	number, symbol := splitNumber(s)
+
+	unit, ok := pb.Memory_UNKNOWN, false
+	for u, sym := range memorySymbol {
+		if strings.EqualFold(symbol, sym) {
+			unit, ok = u, true
+			break
+		}
+	}
+	if !ok && strings.EqualFold(symbol, "byte") {
+		unit, ok = pb.Memory_BYTE, true
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownUnit, symbol)
+	}
+
+	value, ok := new(big.Rat).SetString(number)
+	if !ok || value.Sign() < 0 {
+		return nil, fmt.Errorf("%w: %q", ErrInvalid, s)
+	}
+
+	for i := indexOf(unit); i < len(memoryUnits); i++ {
+		unit = memoryUnits[i]
+		if value.IsInt() {
+			if !value.Num().IsUint64() {
+				return nil, fmt.Errorf("%w: %q", ErrOverflow, s)
+			}
+
+			return &pb.Memory{Value: value.Num().Uint64(), Unit: unit}, nil
+		}
+
+		if i+1 < len(memoryUnits) {
+			factor := int64(1) << (memoryShift[unit] - memoryShift[memoryUnits[i+1]])
+			value.Mul(value, new(big.Rat).SetInt64(factor))
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %q is not a whole number of bits", ErrInvalid, s)
+}
+
+// FormatMemory formats a memory value in the largest unit it reaches, for example "1.5TB".
+func FormatMemory(memory *pb.Memory) string {
+	hi, lo, err := bits128(memory)
+	if err != nil {
+		return "unknown"
+	}
+
+	if hi == 0 && lo == 0 {
+		return "0" + memorySymbol[memory.GetUnit()]
+	}
+
+	total := new(big.Int).Lsh(new(big.Int).SetUint64(hi), 64)
+	total.Or(total, new(big.Int).SetUint64(lo))
+
+	unit := pb.Memory_BIT
+	for _, u := range memoryUnits {
+		if total.Cmp(new(big.Int).Lsh(big.NewInt(1), memoryShift[u])) >= 0 {
+			unit = u
+			break
+		}
+	}
+
+	value := new(big.Rat).SetFrac(total, new(big.Int).Lsh(big.NewInt(1), memoryShift[unit]))
+	number := strings.TrimRight(strings.TrimRight(value.FloatString(2), "0"), ".")
+
+	return number + memorySymbol[unit]
+}
+
+func bits128(memory *pb.Memory) (uint64, uint64, error) {
+	err := ValidateMemory(memory)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	shift := memoryShift[memory.GetUnit()]
+	if shift == 0 {
+		return 0, memory.GetValue(), nil
+	}
+
+	return memory.GetValue() >> (64 - shift), memory.GetValue() << shift, nil
+}
+
+func convert(memory *pb.Memory, unit pb.Memory_Unit) (uint64, error) {
+	shift := memoryShift[memory.GetUnit()] - memoryShift[unit]
+	if memory.GetValue() > math.MaxUint64>>shift {
+		return 0, fmt.Errorf("%w: %d %v", ErrOverflow, memory.GetValue(), memory.GetUnit())
+	}
+
+	return memory.GetValue() << shift, nil
+}
+
+func indexOf(unit pb.Memory_Unit) int {
+	for i, u := range memoryUnits {
+		if u == unit {
+			return i
+		}
+	}
+
+	return len(memoryUnits)
+}
+
+// splitNumber splits a value like "1.5 TB" into its number and unit parts.
+func splitNumber(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 0 {
+		return s, ""
+	}
+
+	return s[:i], strings.TrimSpace(s[i:])
+}
+
+func parseFloat(number, s string) (float64, error) {
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || value < 0 || math.IsInf(value, 0) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalid, s)
+	}
+
+	return value, nil
+}
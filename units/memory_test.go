@@ -0,0 +1,102 @@
+package units
+
+import (
+	"math"
+	"pc-book/pb"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBits(t *testing.T) {
+	t.Parallel()
+
+	value, err := Bits(&pb.Memory{Value: 2, Unit: pb.Memory_KB})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2<<13), value)
+
+	_, err = Bits(&pb.Memory{Value: 1 << 30, Unit: pb.Memory_TB})
+	require.ErrorIs(t, err, ErrOverflow)
+
+	_, err = Bits(&pb.Memory{Value: 1, Unit: pb.Memory_UNKNOWN})
+	require.ErrorIs(t, err, ErrUnknownUnit)
+}
+
+func TestCompareMemory(t *testing.T) {
+	t.Parallel()
+
+	cmp, err := CompareMemory(&pb.Memory{Value: 1, Unit: pb.Memory_TB}, &pb.Memory{Value: 1024, Unit: pb.Memory_GB})
+	require.NoError(t, err)
+	require.Equal(t, 0, cmp)
+
+	cmp, err = CompareMemory(&pb.Memory{Value: math.MaxUint64, Unit: pb.Memory_TB}, &pb.Memory{Value: math.MaxUint64, Unit: pb.Memory_GB})
+	require.NoError(t, err)
+	require.Equal(t, 1, cmp)
+
+	cmp, err = CompareMemory(&pb.Memory{Value: 8, Unit: pb.Memory_GB}, &pb.Memory{Value: 16, Unit: pb.Memory_GB})
+	require.NoError(t, err)
+	require.Equal(t, -1, cmp)
+
+	_, err = CompareMemory(&pb.Memory{Value: 8, Unit: pb.Memory_GB}, &pb.Memory{Value: 16})
+	require.ErrorIs(t, err, ErrUnknownUnit)
+}
+
+func TestAddMemory(t *testing.T) {
+	t.Parallel()
+
+	sum, err := AddMemory(&pb.Memory{Value: 1, Unit: pb.Memory_GB}, &pb.Memory{Value: 512, Unit: pb.Memory_MB})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1536), sum.GetValue())
+	require.Equal(t, pb.Memory_MB, sum.GetUnit())
+
+	_, err = AddMemory(&pb.Memory{Value: math.MaxUint64, Unit: pb.Memory_GB}, &pb.Memory{Value: 1, Unit: pb.Memory_GB})
+	require.ErrorIs(t, err, ErrOverflow)
+}
+
+func TestParseAndFormatMemory(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		input     string
+		value     uint64
+		unit      pb.Memory_Unit
+		formatted string
+	}{
+		{input: "16GB", value: 16, unit: pb.Memory_GB, formatted: "16GB"},
+		{input: "1.5 TB", value: 1536, unit: pb.Memory_GB, formatted: "1.5TB"},
+		{input: "512 mb", value: 512, unit: pb.Memory_MB, formatted: "512MB"},
+		{input: "0.5B", value: 4, unit: pb.Memory_BIT, formatted: "4bit"},
+	}
+
+	for _, tc := range testCases {
+		memory, err := ParseMemory(tc.input)
+		require.NoError(t, err, tc.input)
+		require.Equal(t, tc.value, memory.GetValue(), tc.input)
+		require.Equal(t, tc.unit, memory.GetUnit(), tc.input)
+		require.Equal(t, tc.formatted, FormatMemory(memory), tc.input)
+	}
+
+	_, err := ParseMemory("16 PB")
+	require.ErrorIs(t, err, ErrUnknownUnit)
+
+	_, err = ParseMemory("0.1bit")
+	require.ErrorIs(t, err, ErrInvalid)
+}
+
+func TestWeightAndFrequency(t *testing.T) {
+	t.Parallel()
+
+	kg, err := ParseWeight("2 lb")
+	require.NoError(t, err)
+	require.InDelta(t, 0.907, kg, 1e-3)
+	require.Equal(t, "0.91 kg", FormatWeight(kg))
+
+	ghz, err := ParseFrequency("800 MHz")
+	require.NoError(t, err)
+	require.InDelta(t, 0.8, ghz, 1e-9)
+	require.Equal(t, "800 MHz", FormatFrequency(ghz))
+	require.Equal(t, "2.40 GHz", FormatFrequency(2.4))
+
+	_, err = ParseFrequency("2 Hz")
+	require.ErrorIs(t, err, ErrInvalid)
+}
@@ -0,0 +1,37 @@
+package units
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"pc-book/pb"
+)
+
+const nanosPerUnit = 1e9
+
+// NewMoney builds a Money value from a decimal amount, rounded to the nearest nano.
+func NewMoney(currencyCode string, amount float64) *pb.Money {
+	units, frac := math.Modf(amount)
+	nanos := math.Round(frac * nanosPerUnit)
+	if math.Abs(nanos) >= nanosPerUnit {
+		units += math.Copysign(1, nanos)
+		nanos = 0
+	}
+
+	return &pb.Money{
+		CurrencyCode: strings.ToUpper(currencyCode),
+		Units:        int64(units),
+		Nanos:        int32(nanos),
+	}
+}
+
+// MoneyToFloat returns the decimal amount of a Money value.
+func MoneyToFloat(money *pb.Money) float64 {
+	return float64(money.GetUnits()) + float64(money.GetNanos())/nanosPerUnit
+}
+
+// FormatMoney formats a Money value, for example "1499.99 EUR".
+func FormatMoney(money *pb.Money) string {
+	return fmt.Sprintf("%.2f %s", MoneyToFloat(money), money.GetCurrencyCode())
+}
@@ -0,0 +1,54 @@
+package units
+
+import (
+	"fmt"
+	"strings"
+
+	"pc-book/pb"
+)
+
+const KgPerLb = 0.45359237
+
+// WeightKg returns the laptop weight in kilograms regardless of the unit it was stored with.
+func WeightKg(laptop *pb.Laptop) float64 {
+	switch weight := laptop.GetWeight().(type) {
+	case *pb.Laptop_WeightKg:
+		return weight.WeightKg
+	case *pb.Laptop_WeightLb:
+		return LbToKg(weight.WeightLb)
+	default:
+		return 0
+	}
+}
+
+func LbToKg(lb float64) float64 {
+	return lb * KgPerLb
+}
+
+func KgToLb(kg float64) float64 {
+	return kg / KgPerLb
+}
+
+// ParseWeight parses a weight such as "1.5kg" or "3 lb" and returns it in kilograms.
+func ParseWeight(s string) (float64, error) {
+	number, symbol := splitNumber(s)
+
+	value, err := parseFloat(number, s)
+	if err != nil {
+		return 0, err
+	}
+
+	switch strings.ToLower(symbol) {
+	case "kg":
+		return value, nil
+	case "lb", "lbs":
+		return LbToKg(value), nil
+	default:
+		return 0, fmt.Errorf("%w: unknown weight unit %q", ErrInvalid, symbol)
+	}
+}
+
+// FormatWeight formats a weight in kilograms, for example "1.25 kg".
+func FormatWeight(kg float64) string {
+	return fmt.Sprintf("%.2f kg", kg)
+}